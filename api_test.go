@@ -3,16 +3,30 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"ecommerce-app/config"
 	"ecommerce-app/handlers"
 	"ecommerce-app/models"
+	"ecommerce-app/routes"
 	"ecommerce-app/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -26,13 +40,35 @@ func setupTestDB() *gorm.DB {
 	// Auto migrate all models
 	err = db.AutoMigrate(
 		&models.User{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductOption{},
+		&models.ProductOptionValue{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
 		&models.Order{},
 		&models.OrderItem{},
 		&models.Cart{},
 		&models.CartItem{},
+		&models.StockReservation{},
+		&models.CartReminder{},
+		&models.Wishlist{},
+		&models.WishlistItem{},
+		&models.ProductAlert{},
 		&models.Review{},
+		&models.ReviewVote{},
+		&models.ReviewResponse{},
+		&models.ReviewPhoto{},
+		&models.Conversation{},
+		&models.ConversationParticipant{},
 		&models.Message{},
+		&models.MessageAttachment{},
+		&models.MessageEdit{},
+		&models.UserBlock{},
+		&models.ContentReport{},
+		&models.ModerationAction{},
+		&models.ImportJob{},
+		&models.InventoryMovement{},
 	)
 	if err != nil {
 		panic("failed to migrate database")
@@ -41,6 +77,66 @@ func setupTestDB() *gorm.DB {
 	return db
 }
 
+// newTestRouter returns a router that, in place of the auth middleware,
+// runs every request as the user whose ID is in the X-User header.
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User"))
+		c.Set("user_id", uint(userID))
+		c.Next()
+	})
+	return router
+}
+
+// serve runs req through router as the given user.
+func serve(router http.Handler, req *http.Request, userID uint) *httptest.ResponseRecorder {
+	req.Header.Set("X-User", strconv.Itoa(int(userID)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// doJSON sends body, encoded as JSON unless it is nil, as the given user.
+func doJSON(router http.Handler, method, url string, userID uint, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, url, reader)
+	req.Header.Set("Content-Type", "application/json")
+	return serve(router, req, userID)
+}
+
+// doMultipart posts a form with the given fields and with files, keyed by
+// file name, under fileField, as the given user.
+func doMultipart(router http.Handler, url string, userID uint, fields map[string]string, fileField string, files map[string][]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	for name, data := range files {
+		part, _ := writer.CreateFormFile(fileField, name)
+		part.Write(data)
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", url, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return serve(router, req, userID)
+}
+
+// createUser stores a confirmed user called name with a name@example.com
+// address.
+func createUser(db *gorm.DB, name string) models.User {
+	user := models.User{Email: name + "@example.com", Password: "x", FirstName: name, LastName: "Tester", IsEmailConfirmed: true}
+	db.Create(&user)
+	return user
+}
+
 func TestRegisterEndpoint(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
@@ -112,3 +208,1937 @@ func TestLoginEndpoint(t *testing.T) {
 	// Assertions - should fail because user doesn't exist
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
+
+func TestAddVariantToCart(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+	cartHandler := handlers.NewCartHandler(db)
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	product := models.Product{Name: "T-Shirt", Price: 20, Stock: 0, UserID: seller.ID, IsActive: true}
+	db.Create(&product)
+
+	router := newTestRouter()
+	router.POST("/products/:id/options", productHandler.CreateProductOption)
+	router.POST("/products/:id/variants", productHandler.CreateVariant)
+	router.DELETE("/products/:id/variants/:variant_id", productHandler.DeleteVariant)
+	router.GET("/products/:id", productHandler.GetProduct)
+	router.POST("/cart/add", cartHandler.AddToCart)
+
+	// Create a size option and a variant for one of its values
+	w := doJSON(router, "POST", "/products/1/options", seller.ID, map[string]interface{}{"name": "size", "values": []string{"S", "M"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var option models.ProductOption
+	db.Preload("Values").First(&option)
+	w = doJSON(router, "POST", "/products/1/variants", seller.ID, map[string]interface{}{
+		"sku":              "TS-M",
+		"price":            25.5,
+		"stock":            3,
+		"option_value_ids": []uint{option.Values[1].ID},
+	})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// The variant matrix reports the override price and availability
+	w = doJSON(router, "GET", "/products/1", buyer.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Variants []map[string]interface{} `json:"variants"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Variants, 1)
+	assert.Equal(t, 25.5, response.Variants[0]["price"])
+	assert.Equal(t, true, response.Variants[0]["available"])
+
+	// Products with variants cannot be added without choosing one
+	w = doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": product.ID, "quantity": 1})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var variant models.ProductVariant
+	db.First(&variant)
+	w = doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": product.ID, "variant_id": variant.ID, "quantity": 4})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": product.ID, "variant_id": variant.ID, "quantity": 2})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var item models.CartItem
+	assert.NoError(t, db.First(&item).Error)
+	assert.Equal(t, variant.ID, *item.VariantID)
+
+	// Deleting the variant takes it out of carts and wishlists rather than
+	// leaving items that fall back to the product
+	wishlist := models.Wishlist{UserID: buyer.ID, Name: "Later"}
+	db.Create(&wishlist)
+	db.Create(&models.WishlistItem{WishlistID: wishlist.ID, ProductID: product.ID, VariantID: &variant.ID, Quantity: 1})
+	w = doJSON(router, "DELETE", fmt.Sprintf("/products/%d/variants/%d", product.ID, variant.ID), seller.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var cartItems, wishlistItems int64
+	db.Model(&models.CartItem{}).Where("variant_id = ?", variant.ID).Count(&cartItems)
+	db.Model(&models.WishlistItem{}).Where("variant_id = ?", variant.ID).Count(&wishlistItems)
+	assert.Equal(t, int64(0), cartItems)
+	assert.Equal(t, int64(0), wishlistItems)
+
+	// The deleted variant's SKU can be reused, SKUs are unique per product
+	medium := map[string]interface{}{"sku": "TS-M", "stock": 1, "option_value_ids": []uint{option.Values[1].ID}}
+	small := map[string]interface{}{"sku": "TS-M", "stock": 1, "option_value_ids": []uint{option.Values[0].ID}}
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/products/1/variants", seller.ID, medium).Code)
+	assert.Equal(t, http.StatusConflict, doJSON(router, "POST", "/products/1/variants", seller.ID, small).Code)
+
+	mug := models.Product{Name: "Mug", Price: 8, UserID: seller.ID, IsActive: true}
+	db.Create(&mug)
+	w = doJSON(router, "POST", fmt.Sprintf("/products/%d/variants", mug.ID), seller.ID, map[string]interface{}{"sku": "TS-M", "stock": 1})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	// Variants deleted before SKUs were released get theirs back on migration
+	legacySKU := "TS-L"
+	legacy := models.ProductVariant{ProductID: mug.ID, SKU: &legacySKU, IsActive: true}
+	db.Create(&legacy)
+	db.Delete(&legacy)
+	assert.NoError(t, config.MigrateVariantSKUs(db))
+	db.Unscoped().First(&legacy, legacy.ID)
+	assert.Nil(t, legacy.SKU)
+}
+
+func TestProductSearchRanking(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	// The better match is the older product, so id order would rank it last
+	db.Create(&models.Product{Name: "Running Shoes", Description: "Lightweight", Price: 80, UserID: seller.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Sun Hat", Description: "Goes well with running shoes", Price: 15, UserID: seller.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Umbrella", Price: 10, UserID: seller.ID, IsActive: true})
+
+	router := gin.New()
+	router.GET("/products", productHandler.GetProducts)
+
+	req, _ := http.NewRequest("GET", "/products?search=runn%20SHOE", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Name matches rank above description matches, non-matches are excluded
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Products []models.Product `json:"products"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	if assert.Len(t, response.Products, 2) {
+		assert.Equal(t, "Running Shoes", response.Products[0].Name)
+		assert.Equal(t, "Sun Hat", response.Products[1].Name)
+	}
+}
+
+func TestProductFacets(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	shoes := models.Category{Name: "Shoes", Slug: "shoes"}
+	hats := models.Category{Name: "Hats", Slug: "hats"}
+	db.Create(&shoes)
+	db.Create(&hats)
+	db.Create(&models.Product{Name: "Runner", Price: 80, Stock: 3, CategoryID: &shoes.ID, UserID: seller.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Boot", Price: 120, Stock: 0, CategoryID: &shoes.ID, UserID: seller.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Cap", Price: 12, Stock: 5, CategoryID: &hats.ID, UserID: seller.ID, IsActive: true})
+
+	router := gin.New()
+	router.GET("/products", productHandler.GetProducts)
+
+	req, _ := http.NewRequest("GET", "/products?category=shoes&facets=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Products []models.Product `json:"products"`
+		Facets   struct {
+			Categories []struct {
+				Slug  string `json:"slug"`
+				Count int    `json:"count"`
+			} `json:"categories"`
+			Price []struct {
+				Min   float64 `json:"min"`
+				Count int     `json:"count"`
+			} `json:"price"`
+			Availability map[string]int `json:"availability"`
+		} `json:"facets"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Products, 2)
+
+	// The category facet ignores the selected category, the others honor it
+	assert.Len(t, response.Facets.Categories, 2)
+	assert.Equal(t, "shoes", response.Facets.Categories[0].Slug)
+	assert.Equal(t, 2, response.Facets.Categories[0].Count)
+	assert.Equal(t, 1, response.Facets.Price[2].Count)
+	assert.Equal(t, 1, response.Facets.Price[3].Count)
+	assert.Equal(t, map[string]int{"in_stock": 1, "out_of_stock": 1}, response.Facets.Availability)
+}
+
+func TestCategories(t *testing.T) {
+	// Setup
+	db := setupTestDB()
+	categoryHandler := handlers.NewCategoryHandler(db)
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	admin := createUser(db, "admin")
+	db.Model(&admin).Update("is_admin", true)
+
+	router := newTestRouter()
+	router.GET("/categories", categoryHandler.GetCategories)
+	router.GET("/categories/:id", categoryHandler.GetCategory)
+	router.POST("/categories", categoryHandler.CreateCategory)
+	router.PUT("/categories/:id", categoryHandler.UpdateCategory)
+	router.DELETE("/categories/:id", categoryHandler.DeleteCategory)
+	router.GET("/products", productHandler.GetProducts)
+
+	create := func(body gin.H) models.Category {
+		w := doJSON(router, "POST", "/categories", admin.ID, body)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var response struct {
+			Category models.Category `json:"category"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Category
+	}
+
+	// Slugs are derived from the name and unique
+	clothing := create(gin.H{"name": "Clothing"})
+	assert.Equal(t, "clothing", clothing.Slug)
+	shoes := create(gin.H{"name": "Running Shoes", "parent_id": clothing.ID})
+	assert.Equal(t, "running-shoes", shoes.Slug)
+	trail := create(gin.H{"name": "Trail", "parent_id": shoes.ID})
+	assert.Equal(t, http.StatusConflict, doJSON(router, "POST", "/categories", admin.ID, gin.H{"name": "clothing"}).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "POST", "/categories", admin.ID, gin.H{"name": "Hats", "parent_id": 99}).Code)
+
+	var tree struct {
+		Categories []models.Category `json:"categories"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/categories", admin.ID, nil).Body.Bytes(), &tree)
+	if assert.Len(t, tree.Categories, 1) && assert.Len(t, tree.Categories[0].Children, 1) {
+		assert.Equal(t, trail.ID, tree.Categories[0].Children[0].Children[0].ID)
+	}
+
+	var detail struct {
+		Path []struct {
+			Slug string `json:"slug"`
+		} `json:"path"`
+	}
+	w := doJSON(router, "GET", "/categories/trail", admin.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	if assert.Len(t, detail.Path, 3) {
+		assert.Equal(t, "clothing", detail.Path[0].Slug)
+	}
+
+	// Filtering by a category includes the products of its descendants
+	db.Create(&models.Product{Name: "Trail Runner", Price: 90, Stock: 1, CategoryID: &trail.ID, UserID: admin.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Road Runner", Price: 80, Stock: 1, CategoryID: &shoes.ID, UserID: admin.ID, IsActive: true})
+	db.Create(&models.Product{Name: "Scarf", Price: 20, Stock: 1, UserID: admin.ID, IsActive: true})
+	productCount := func(category string) int {
+		var response struct {
+			Products []models.Product `json:"products"`
+		}
+		json.Unmarshal(doJSON(router, "GET", "/products?category="+category, admin.ID, nil).Body.Bytes(), &response)
+		return len(response.Products)
+	}
+	assert.Equal(t, 2, productCount("clothing"))
+	assert.Equal(t, 2, productCount("running-shoes"))
+	assert.Equal(t, 1, productCount("trail"))
+
+	// A category cannot be moved below itself or its descendants
+	clothingURL := fmt.Sprintf("/categories/%d", clothing.ID)
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "PUT", clothingURL, admin.ID, gin.H{"parent_id": clothing.ID}).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "PUT", clothingURL, admin.ID, gin.H{"parent_id": trail.ID}).Code)
+	w = doJSON(router, "PUT", fmt.Sprintf("/categories/%d", trail.ID), admin.ID, gin.H{"make_root": true, "name": "Trail Shoes", "slug": "Trail Shoes"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.First(&trail, trail.ID)
+	assert.Nil(t, trail.ParentID)
+	assert.Equal(t, "trail-shoes", trail.Slug)
+	assert.Equal(t, 1, productCount("running-shoes"))
+
+	// Categories in use cannot be deleted, and deleted slugs can be reused
+	assert.Equal(t, http.StatusConflict, doJSON(router, "DELETE", clothingURL, admin.ID, nil).Code)
+	assert.Equal(t, http.StatusConflict, doJSON(router, "DELETE", fmt.Sprintf("/categories/%d", shoes.ID), admin.ID, nil).Code)
+	hats := create(gin.H{"name": "Hats"})
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", fmt.Sprintf("/categories/%d", hats.ID), admin.ID, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", "/categories/hats", admin.ID, nil).Code)
+	create(gin.H{"name": "Hats"})
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", fmt.Sprintf("/categories/%d", shoes.ID), admin.ID, gin.H{"slug": "shoes"}).Code)
+
+	// Databases created before slugs could be reused lose the old index
+	db.Exec("CREATE UNIQUE INDEX idx_categories_slug ON categories(slug)")
+	assert.NoError(t, config.MigrateCategorySlugs(db))
+	assert.False(t, db.Migrator().HasIndex(&models.Category{}, "idx_categories_slug"))
+}
+
+func TestMigrateProductCategories(t *testing.T) {
+	db := setupTestDB()
+	seller := createUser(db, "seller")
+	assert.NoError(t, config.MigrateProductCategories(db))
+
+	// Products from before the category tree have a free-text category
+	assert.NoError(t, db.Exec("ALTER TABLE products ADD COLUMN category text").Error)
+	boots := models.Category{Name: "Boots", Slug: "boots"}
+	db.Create(&boots)
+	var products []models.Product
+	for _, name := range []string{"Shoes", " shoes", "Hats", ""} {
+		product := models.Product{Name: "Item", Price: 10, UserID: seller.ID, IsActive: true}
+		db.Create(&product)
+		db.Model(&product).UpdateColumn("category", name)
+		products = append(products, product)
+	}
+	categorized := models.Product{Name: "Boot", Price: 10, CategoryID: &boots.ID, UserID: seller.ID, IsActive: true}
+	db.Create(&categorized)
+	db.Model(&categorized).UpdateColumn("category", "Shoes")
+
+	// Names that slugify the same share a category, and reruns change nothing
+	assert.NoError(t, config.MigrateProductCategories(db))
+	assert.NoError(t, config.MigrateProductCategories(db))
+	var categories []models.Category
+	db.Order("slug").Find(&categories)
+	if assert.Len(t, categories, 3) {
+		assert.Equal(t, []string{"boots", "hats", "shoes"}, []string{categories[0].Slug, categories[1].Slug, categories[2].Slug})
+	}
+	for i := range products {
+		db.First(&products[i], products[i].ID)
+	}
+	if assert.NotNil(t, products[0].CategoryID) && assert.NotNil(t, products[1].CategoryID) {
+		assert.Equal(t, *products[0].CategoryID, *products[1].CategoryID)
+	}
+	assert.NotNil(t, products[2].CategoryID)
+	assert.Nil(t, products[3].CategoryID)
+	db.First(&categorized, categorized.ID)
+	assert.Equal(t, boots.ID, *categorized.CategoryID)
+}
+
+func TestProductCursorPagination(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	for _, price := range []float64{30, 10, 20, 10} {
+		db.Create(&models.Product{Name: "Item", Price: price, UserID: seller.ID, IsActive: true})
+	}
+
+	router := gin.New()
+	router.GET("/products", productHandler.GetProducts)
+
+	type page struct {
+		Products   []models.Product `json:"products"`
+		Pagination struct {
+			Total      int    `json:"total"`
+			NextCursor string `json:"next_cursor"`
+		} `json:"pagination"`
+	}
+	fetch := func(url string) (int, page) {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response page
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	code, first := fetch("/products?sort=price_asc&limit=3")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 4, first.Pagination.Total)
+	if assert.Len(t, first.Products, 3) {
+		assert.Equal(t, []uint{2, 4, 3}, []uint{first.Products[0].ID, first.Products[1].ID, first.Products[2].ID})
+	}
+	assert.NotEmpty(t, first.Pagination.NextCursor)
+
+	code, second := fetch("/products?sort=price_asc&limit=3&cursor=" + first.Pagination.NextCursor)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, second.Products, 1) {
+		assert.Equal(t, uint(1), second.Products[0].ID)
+	}
+	assert.Empty(t, second.Pagination.NextCursor)
+
+	code, _ = fetch("/products?sort=cheapest")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = fetch("/products?cursor=not-a-cursor")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestUploadProductImage(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	uploadDir := t.TempDir()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(uploadDir, "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	product := models.Product{Name: "Poster", Price: 9, UserID: seller.ID, IsActive: true}
+	db.Create(&product)
+
+	router := newTestRouter()
+	router.POST("/products/:id/images", productHandler.UploadProductImages)
+	router.DELETE("/products/:id", productHandler.DeleteProduct)
+
+	upload := func(filename string, data []byte) *httptest.ResponseRecorder {
+		return doMultipart(router, "/products/1/images", seller.ID, nil, "images", map[string][]byte{filename: data})
+	}
+
+	// Non-images are rejected by content sniffing
+	w := upload("notes.png", []byte("definitely not an image"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 800, 400)))
+	w = upload("poster.png", encoded.Bytes())
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var stored models.ProductImage
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, 800, stored.Width)
+
+	// The thumbnail fits the configured square and the primary image is mirrored
+	thumbnail, err := os.Open(filepath.Join(uploadDir, filepath.FromSlash(stored.ThumbnailKey)))
+	assert.NoError(t, err)
+	config, _, err := image.DecodeConfig(thumbnail)
+	thumbnail.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 320, config.Width)
+	assert.Equal(t, 160, config.Height)
+	db.First(&product, product.ID)
+	assert.Equal(t, stored.URL, product.ImageURL)
+
+	// Deleting the product removes its files
+	w = doJSON(router, "DELETE", "/products/1", seller.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = os.Stat(filepath.Join(uploadDir, filepath.FromSlash(stored.StorageKey)))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImportProductsCSV(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	db.Create(&models.Category{Name: "Shoes", Slug: "shoes"})
+	sku := "BOOT-1"
+	db.Create(&models.Product{Name: "Old Boot", Price: 50, Stock: 1, SKU: &sku, UserID: seller.ID, IsActive: true})
+
+	router := newTestRouter()
+	router.POST("/products/import", productHandler.ImportProducts)
+	router.GET("/products/export", productHandler.ExportProducts)
+
+	csvData := "sku,name,price,stock,category\n" +
+		"BOOT-1,Boot,60,5,shoes\n" +
+		"SNEAK-1,Sneaker,40,3,shoes\n" +
+		",Sandal,-1,2,shoes\n" +
+		"HAT-1,Hat,15,1,hats\n"
+	importCSV := func(url string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(csvData))
+		req.Header.Set("Content-Type", "text/csv")
+		w := serve(router, req, seller.ID)
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	// A dry run reports the outcome without writing anything
+	code, response := importCSV("/products/import?dry_run=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), response["created"])
+	assert.Equal(t, float64(1), response["updated"])
+	assert.Len(t, response["errors"], 2)
+	var count int64
+	db.Model(&models.Product{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	code, response = importCSV("/products/import")
+	assert.Equal(t, http.StatusOK, code)
+	errors := response["errors"].([]interface{})
+	if assert.Len(t, errors, 2) {
+		first := errors[0].(map[string]interface{})
+		assert.Equal(t, float64(4), first["row"])
+		assert.Equal(t, "price", first["column"])
+	}
+
+	var boot models.Product
+	db.Where("sku = ?", "BOOT-1").First(&boot)
+	assert.Equal(t, "Boot", boot.Name)
+	assert.Equal(t, 60.0, boot.Price)
+	assert.Equal(t, 5, boot.Stock)
+	assert.NotNil(t, boot.CategoryID)
+
+	w := doJSON(router, "GET", "/products/export", seller.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "sku,name,description,price,stock,category,image_url,is_active\n")
+	assert.Contains(t, w.Body.String(), "SNEAK-1,Sneaker,,40,3,shoes,,true\n")
+
+	// New rows imported as inactive stay drafts
+	req, _ := http.NewRequest("POST", "/products/import", strings.NewReader("sku,name,price,is_active\nX1,Hidden,10,false\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	w = serve(router, req, seller.ID)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var hidden models.Product
+	assert.NoError(t, db.Where("sku = ?", "X1").First(&hidden).Error)
+	assert.False(t, hidden.IsActive)
+}
+
+func TestInventoryLedger(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+
+	router := newTestRouter()
+	router.POST("/products", productHandler.CreateProduct)
+	router.PUT("/products/:id", productHandler.UpdateProduct)
+	router.GET("/products/:id/inventory", productHandler.GetInventoryMovements)
+	router.POST("/products/:id/inventory", productHandler.AdjustStock)
+
+	w := doJSON(router, "POST", "/products", seller.ID, gin.H{"name": "Lamp", "price": 25, "stock": 5})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = doJSON(router, "PUT", "/products/1", seller.ID, gin.H{"stock": 8, "stock_reason": "Counted shelf"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	// Updates that leave out stock must not touch it
+	w = doJSON(router, "PUT", "/products/1", seller.ID, gin.H{"name": "Desk Lamp"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doJSON(router, "POST", "/products/1/inventory", seller.ID, gin.H{"type": "adjustment", "quantity": -2, "reason": "Damaged"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = doJSON(router, "POST", "/products/1/inventory", seller.ID, gin.H{"type": "adjustment", "quantity": -10})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var product models.Product
+	db.First(&product, 1)
+	assert.Equal(t, 6, product.Stock)
+
+	w = doJSON(router, "GET", "/products/1/inventory", seller.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Movements []models.InventoryMovement `json:"movements"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if assert.Len(t, response.Movements, 3) {
+		assert.Equal(t, models.InventoryMovementAdjustment, response.Movements[0].Type)
+		assert.Equal(t, -2, response.Movements[0].Quantity)
+		assert.Equal(t, 6, response.Movements[0].StockAfter)
+		assert.Equal(t, "Counted shelf", response.Movements[1].Reason)
+		assert.Equal(t, models.InventoryMovementRestock, response.Movements[2].Type)
+	}
+
+	// Stock changed behind the ledger's back shows up as drift
+	drifts, err := services.ReconcileInventory(db, false)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	db.Model(&product).UpdateColumn("stock", 9)
+	drifts, err = services.ReconcileInventory(db, true)
+	assert.NoError(t, err)
+	if assert.Len(t, drifts, 1) {
+		assert.Equal(t, 3, drifts[0].Difference())
+	}
+	drifts, err = services.ReconcileInventory(db, false)
+	assert.NoError(t, err)
+	assert.Empty(t, drifts)
+}
+
+func TestCheckoutStockReservations(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	cartHandler := handlers.NewCartHandler(db)
+
+	seller := createUser(db, "seller")
+	alice := createUser(db, "alice")
+	bob := createUser(db, "bob")
+	product := models.Product{Name: "Vase", Price: 30, Stock: 3, UserID: seller.ID, IsActive: true}
+	db.Create(&product)
+
+	router := newTestRouter()
+	router.POST("/cart/add", cartHandler.AddToCart)
+	router.POST("/cart/checkout", cartHandler.StartCheckout)
+	router.DELETE("/cart/items/:id", cartHandler.RemoveFromCart)
+
+	addVase := func(user models.User, quantity int) int {
+		return doJSON(router, "POST", "/cart/add", user.ID, gin.H{"product_id": product.ID, "quantity": quantity}).Code
+	}
+
+	// Alice reserves two vases, leaving one for everybody else
+	assert.Equal(t, http.StatusOK, addVase(alice, 2))
+	w := doJSON(router, "POST", "/cart/checkout", alice.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "reserved_until")
+
+	// A renewal that cannot reserve everything keeps the earlier holds
+	aliceItems := db.Model(&models.CartItem{}).Where("cart_id IN (?)", db.Model(&models.Cart{}).Select("id").Where("user_id = ?", alice.ID))
+	aliceItems.Session(&gorm.Session{}).Update("quantity", 5)
+	assert.Equal(t, http.StatusConflict, doJSON(router, "POST", "/cart/checkout", alice.ID, nil).Code)
+	var held int64
+	db.Model(&models.StockReservation{}).Where("quantity = ?", 2).Count(&held)
+	assert.Equal(t, int64(1), held)
+	aliceItems.Session(&gorm.Session{}).Update("quantity", 2)
+
+	assert.Equal(t, http.StatusBadRequest, addVase(bob, 2))
+	assert.Equal(t, http.StatusOK, addVase(bob, 1))
+
+	// Bob cannot reserve more than is left
+	assert.Equal(t, http.StatusBadRequest, addVase(bob, 1))
+
+	// Removing the item releases Alice's reservation
+	var aliceItem models.CartItem
+	db.Joins("JOIN carts ON carts.id = cart_items.cart_id").Where("carts.user_id = ?", alice.ID).First(&aliceItem)
+	w = doJSON(router, "DELETE", fmt.Sprintf("/cart/items/%d", aliceItem.ID), alice.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, addVase(bob, 1))
+
+	// Expired reservations no longer hold stock
+	w = doJSON(router, "POST", "/cart/checkout", bob.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusBadRequest, addVase(alice, 2))
+	db.Model(&models.StockReservation{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+	assert.Equal(t, http.StatusOK, addVase(alice, 2))
+
+	released, err := services.ReleaseExpiredReservations(db)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+}
+
+func TestGuestCartMergeOnLogin(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	db := setupTestDB()
+	cartHandler := handlers.NewCartHandler(db)
+	authHandler := handlers.NewAuthHandler(db, services.NewMockEmailService())
+
+	password, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	seller := createUser(db, "seller")
+	buyer := models.User{Email: "buyer@example.com", Password: string(password), FirstName: "Bea", LastName: "Buyer", IsEmailConfirmed: true}
+	db.Create(&buyer)
+	mug := models.Product{Name: "Mug", Price: 8, Stock: 3, UserID: seller.ID, IsActive: true}
+	plate := models.Product{Name: "Plate", Price: 12, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&mug)
+	db.Create(&plate)
+
+	// The buyer already has two mugs in their cart, added at an older price
+	buyerCart := models.Cart{UserID: &buyer.ID}
+	db.Create(&buyerCart)
+	db.Create(&models.CartItem{CartID: buyerCart.ID, ProductID: mug.ID, Quantity: 2, PriceAtAdd: 7})
+
+	router := gin.New()
+	router.GET("/cart", cartHandler.GetCart)
+	router.POST("/cart/add", cartHandler.AddToCart)
+	router.POST("/login", authHandler.Login)
+
+	send := func(method, url, token string, body interface{}) *httptest.ResponseRecorder {
+		jsonData, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("X-Cart-Token", token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A guest gets a cart token with the first item
+	w := send("POST", "/cart/add", "", map[string]interface{}{"product_id": mug.ID, "quantity": 2})
+	assert.Equal(t, http.StatusOK, w.Code)
+	token := w.Header().Get("X-Cart-Token")
+	assert.NotEmpty(t, token)
+
+	w = send("POST", "/cart/add", token, map[string]interface{}{"product_id": plate.ID, "quantity": 1})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Cart-Token"))
+
+	w = send("GET", "/cart", token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, send("GET", "/cart", token+"x", nil).Code)
+
+	// Logging in merges the guest cart, capping the mugs at the stock
+	w = send("POST", "/login", token, map[string]interface{}{"email": buyer.Email, "password": "password123"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		CartMerge struct {
+			Adjustments []map[string]interface{} `json:"adjustments"`
+		} `json:"cart_merge"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if assert.Len(t, response.CartMerge.Adjustments, 1) {
+		assert.Equal(t, float64(4), response.CartMerge.Adjustments[0]["requested"])
+		assert.Equal(t, float64(3), response.CartMerge.Adjustments[0]["quantity"])
+	}
+
+	var items []models.CartItem
+	db.Where("cart_id = ?", buyerCart.ID).Order("product_id").Find(&items)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, 3, items[0].Quantity)
+		assert.Equal(t, 1, items[1].Quantity)
+		// Price snapshots survive the merge, the user's own taking precedence
+		assert.Equal(t, 7.0, items[0].PriceAtAdd)
+		assert.Equal(t, 12.0, items[1].PriceAtAdd)
+	}
+
+	// The guest cart is gone
+	assert.Equal(t, http.StatusNotFound, send("GET", "/cart", token, nil).Code)
+}
+
+func TestCartChangeWarnings(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	cartHandler := handlers.NewCartHandler(db)
+	orderHandler := handlers.NewOrderHandler(db, services.NewPaymentService(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	rug := models.Product{Name: "Rug", Price: 90, Stock: 2, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	db.Create(&rug)
+
+	router := newTestRouter()
+	router.GET("/cart", cartHandler.GetCart)
+	router.POST("/cart/add", cartHandler.AddToCart)
+	router.POST("/orders", orderHandler.CreateOrder)
+
+	type cartResponse struct {
+		Warnings []struct {
+			ProductID     uint     `json:"product_id"`
+			Code          string   `json:"code"`
+			PreviousPrice *float64 `json:"previous_price"`
+			CurrentPrice  *float64 `json:"current_price"`
+		} `json:"warnings"`
+	}
+	getWarnings := func() cartResponse {
+		var response cartResponse
+		json.Unmarshal(doJSON(router, "GET", "/cart", buyer.ID, nil).Body.Bytes(), &response)
+		return response
+	}
+
+	assert.Equal(t, http.StatusOK, doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": lamp.ID, "quantity": 1}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": rug.ID, "quantity": 2}).Code)
+	assert.Empty(t, getWarnings().Warnings)
+
+	// A price rise needs to be acknowledged
+	db.Model(&lamp).Update("price", 45)
+	response := getWarnings()
+	if assert.Len(t, response.Warnings, 1) {
+		assert.Equal(t, "price_changed", response.Warnings[0].Code)
+		assert.Equal(t, 40.0, *response.Warnings[0].PreviousPrice)
+		assert.Equal(t, 45.0, *response.Warnings[0].CurrentPrice)
+	}
+	w := doJSON(router, "POST", "/orders", buyer.ID, map[string]interface{}{"shipping_address": "1 Main St"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Stock drops and deletions block checkout even when acknowledged
+	db.Model(&rug).Update("stock", 1)
+	db.Delete(&lamp)
+	response = getWarnings()
+	codes := []string{}
+	for _, warning := range response.Warnings {
+		codes = append(codes, warning.Code)
+	}
+	assert.ElementsMatch(t, []string{"deleted", "out_of_stock"}, codes)
+
+	w = doJSON(router, "POST", "/orders", buyer.ID, map[string]interface{}{"shipping_address": "1 Main St", "acknowledge_changes": true})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "can no longer be ordered")
+}
+
+func TestWishlistsAndSaveForLater(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	cartHandler := handlers.NewCartHandler(db)
+	wishlistHandler := handlers.NewWishlistHandler(db)
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+
+	router := newTestRouter()
+	router.GET("/wishlists/shared/:token", wishlistHandler.GetSharedWishlist)
+	router.GET("/cart", cartHandler.GetCart)
+	router.POST("/cart/add", cartHandler.AddToCart)
+	router.POST("/cart/items/:id/save-for-later", wishlistHandler.SaveForLater)
+	router.POST("/wishlists", wishlistHandler.CreateWishlist)
+	router.GET("/wishlists", wishlistHandler.GetWishlists)
+	router.PUT("/wishlists/:id", wishlistHandler.UpdateWishlist)
+	router.POST("/wishlists/:id/items/:item_id/move-to-cart", wishlistHandler.MoveToCart)
+
+	// Save a cart item for later
+	assert.Equal(t, http.StatusOK, doJSON(router, "POST", "/cart/add", buyer.ID, map[string]interface{}{"product_id": lamp.ID, "quantity": 2}).Code)
+	var cartItem models.CartItem
+	db.First(&cartItem)
+	w := doJSON(router, "POST", fmt.Sprintf("/cart/items/%d/save-for-later", cartItem.ID), buyer.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var moved struct {
+		WishlistID uint                `json:"wishlist_id"`
+		Item       models.WishlistItem `json:"item"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &moved)
+	assert.Equal(t, 2, moved.Item.Quantity)
+	var cartItems int64
+	db.Model(&models.CartItem{}).Count(&cartItems)
+	assert.Equal(t, int64(0), cartItems)
+
+	// A discount is pointed out on the list
+	db.Model(&lamp).Update("price", 30)
+	var listResponse struct {
+		Wishlists []struct {
+			SaveForLater bool `json:"save_for_later"`
+			Items        []struct {
+				CurrentPrice float64 `json:"current_price"`
+				PriceAtAdd   float64 `json:"price_at_add"`
+				PriceDropped bool    `json:"price_dropped"`
+				Available    int     `json:"available"`
+			} `json:"items"`
+		} `json:"wishlists"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/wishlists", buyer.ID, nil).Body.Bytes(), &listResponse)
+	if assert.Len(t, listResponse.Wishlists, 1) && assert.Len(t, listResponse.Wishlists[0].Items, 1) {
+		item := listResponse.Wishlists[0].Items[0]
+		assert.True(t, listResponse.Wishlists[0].SaveForLater)
+		assert.Equal(t, 40.0, item.PriceAtAdd)
+		assert.Equal(t, 30.0, item.CurrentPrice)
+		assert.True(t, item.PriceDropped)
+		assert.Equal(t, 5, item.Available)
+	}
+
+	// Move it back to the cart
+	w = doJSON(router, "POST", fmt.Sprintf("/wishlists/%d/items/%d/move-to-cart", moved.WishlistID, moved.Item.ID), buyer.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	db.Model(&models.CartItem{}).Count(&cartItems)
+	assert.Equal(t, int64(1), cartItems)
+
+	// The save-for-later list cannot be shared, named lists can
+	w = doJSON(router, "PUT", fmt.Sprintf("/wishlists/%d", moved.WishlistID), buyer.ID, map[string]interface{}{"is_public": true})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = doJSON(router, "POST", "/wishlists", buyer.ID, map[string]interface{}{"name": "Birthday", "is_public": true})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		Wishlist models.Wishlist `json:"wishlist"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if assert.NotNil(t, created.Wishlist.ShareToken) {
+		token := *created.Wishlist.ShareToken
+		assert.Equal(t, http.StatusOK, doJSON(router, "GET", "/wishlists/shared/"+token, 0, nil).Code)
+
+		// Making it private revokes the link
+		w = doJSON(router, "PUT", fmt.Sprintf("/wishlists/%d", created.Wishlist.ID), buyer.ID, map[string]interface{}{"is_public": false})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", "/wishlists/shared/"+token, 0, nil).Code)
+	}
+}
+
+// recordingEmailService records product alert emails.
+type recordingEmailService struct {
+	services.MockEmailService
+	alerts []string
+}
+
+func (s *recordingEmailService) SendBackInStock(email, productName string, productID uint) error {
+	s.alerts = append(s.alerts, "restock:"+email)
+	return nil
+}
+
+func (s *recordingEmailService) SendPriceDrop(email, productName string, productID uint, oldPrice, newPrice float64) error {
+	s.alerts = append(s.alerts, fmt.Sprintf("price_drop:%s:%.0f", email, newPrice))
+	return nil
+}
+
+func (s *recordingEmailService) SendCartReminder(email string, itemCount int, cartURL string) error {
+	s.alerts = append(s.alerts, fmt.Sprintf("cart:%s:%d", email, itemCount))
+	return nil
+}
+
+func (s *recordingEmailService) SendReviewResponse(email, productName string, productID uint, response string) error {
+	s.alerts = append(s.alerts, "response:"+email+":"+response)
+	return nil
+}
+
+func TestProductAlerts(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	emails := &recordingEmailService{}
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, emails, nil))
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 0, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+
+	router := newTestRouter()
+	router.POST("/products/:id/alerts", productHandler.SubscribeProductAlert)
+	router.PUT("/products/:id", productHandler.UpdateProduct)
+
+	productURL := fmt.Sprintf("/products/%d", lamp.ID)
+
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", productURL+"/alerts", buyer.ID, map[string]interface{}{"type": "restock"}).Code)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", productURL+"/alerts", buyer.ID, map[string]interface{}{"type": "price_drop"}).Code)
+
+	// Restocking alerts once, later stock changes do not repeat it
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"stock": 3}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"stock": 0}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"stock": 5}).Code)
+	assert.Equal(t, []string{"restock:buyer@example.com"}, emails.alerts)
+
+	// Restock alerts cannot be set while the product is in stock
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "POST", productURL+"/alerts", buyer.ID, map[string]interface{}{"type": "restock"}).Code)
+
+	// Each new low price alerts once, price rises do not
+	emails.alerts = nil
+	doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"price": 35})
+	doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"price": 38})
+	doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"price": 36})
+	doJSON(router, "PUT", productURL, seller.ID, map[string]interface{}{"price": 30})
+	assert.Equal(t, []string{"price_drop:buyer@example.com:35", "price_drop:buyer@example.com:30"}, emails.alerts)
+}
+
+func TestRestockAlertsForVariantsAndReturnedStock(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	emails := &recordingEmailService{}
+	alerts := services.NewProductAlertService(db, emails, nil)
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), alerts)
+	orderHandler := handlers.NewOrderHandler(db, services.NewPaymentService(), alerts)
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	shirt := models.Product{Name: "Shirt", Price: 20, Stock: 0, UserID: seller.ID, IsActive: true}
+	vase := models.Product{Name: "Vase", Price: 30, Stock: 0, UserID: seller.ID, IsActive: true}
+	bowl := models.Product{Name: "Bowl", Price: 10, Stock: 0, UserID: seller.ID, IsActive: true}
+	db.Create(&shirt)
+	db.Create(&vase)
+	db.Create(&bowl)
+	smallSKU := "SHIRT-S"
+	small := models.ProductVariant{ProductID: shirt.ID, SKU: &smallSKU, Stock: 0, IsActive: true}
+	db.Create(&small)
+	order := models.Order{UserID: buyer.ID, Status: models.OrderStatusPending, TotalAmount: 30, ShippingAddress: "1 Main St"}
+	db.Create(&order)
+	db.Create(&models.OrderItem{OrderID: order.ID, ProductID: vase.ID, Quantity: 1, Price: 30})
+
+	router := newTestRouter()
+	router.POST("/products/:id/alerts", productHandler.SubscribeProductAlert)
+	router.PUT("/products/:id/variants/:variant_id", productHandler.UpdateVariant)
+	router.POST("/products/:id/inventory", productHandler.AdjustStock)
+	router.PUT("/orders/:id/status", orderHandler.UpdateOrderStatus)
+
+	subscribe := func(product models.Product) int {
+		return doJSON(router, "POST", fmt.Sprintf("/products/%d/alerts", product.ID), buyer.ID, map[string]interface{}{"type": "restock"}).Code
+	}
+	for _, product := range []models.Product{shirt, vase, bowl} {
+		assert.Equal(t, http.StatusCreated, subscribe(product))
+	}
+
+	// Restocking a variant alerts, and the product then counts as in stock
+	w := doJSON(router, "PUT", fmt.Sprintf("/products/%d/variants/%d", shirt.ID, small.ID), seller.ID, map[string]interface{}{"stock": 2})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"restock:buyer@example.com"}, emails.alerts)
+	assert.Equal(t, http.StatusBadRequest, subscribe(shirt))
+
+	// Sellers cannot cancel, and so restock, orders shared with other sellers
+	other := createUser(db, "other")
+	mug := models.Product{Name: "Mug", Price: 8, Stock: 0, UserID: other.ID, IsActive: true}
+	db.Create(&mug)
+	shared := models.Order{UserID: buyer.ID, Status: models.OrderStatusPending, TotalAmount: 38, ShippingAddress: "1 Main St"}
+	db.Create(&shared)
+	db.Create(&models.OrderItem{OrderID: shared.ID, ProductID: vase.ID, Quantity: 1, Price: 30})
+	db.Create(&models.OrderItem{OrderID: shared.ID, ProductID: mug.ID, Quantity: 1, Price: 8})
+	w = doJSON(router, "PUT", fmt.Sprintf("/orders/%d/status", shared.ID), seller.ID, map[string]interface{}{"status": "cancelled"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	db.First(&mug, mug.ID)
+	assert.Equal(t, 0, mug.Stock)
+
+	// Stock returned by a cancelled order alerts
+	emails.alerts = nil
+	w = doJSON(router, "PUT", fmt.Sprintf("/orders/%d/status", order.ID), seller.ID, map[string]interface{}{"status": "cancelled"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"restock:buyer@example.com"}, emails.alerts)
+
+	// So does an inventory restock
+	emails.alerts = nil
+	w = doJSON(router, "POST", fmt.Sprintf("/products/%d/inventory", bowl.ID), seller.ID, map[string]interface{}{"type": "restock", "quantity": 4})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, []string{"restock:buyer@example.com"}, emails.alerts)
+}
+
+func TestAbandonedCartReminders(t *testing.T) {
+	// Setup
+	db := setupTestDB()
+	emails := &recordingEmailService{}
+	config := services.CartReminderConfig{AbandonedAfter: time.Hour, MaxReminders: 2, CartURL: "http://shop.test/cart"}
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	quiet := createUser(db, "quiet")
+	db.Model(&quiet).Update("cart_reminders_opt_out", true)
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+
+	// Backdates the carts by d
+	age := func(d time.Duration) {
+		db.Model(&models.Cart{}).Where("1 = 1").Update("created_at", time.Now().Add(-d))
+		db.Model(&models.CartItem{}).Where("1 = 1").Update("updated_at", time.Now().Add(-d))
+	}
+	var carts []models.Cart
+	for _, user := range []models.User{buyer, quiet} {
+		cart := models.Cart{UserID: &user.ID}
+		db.Create(&cart)
+		db.Create(&models.CartItem{CartID: cart.ID, ProductID: lamp.ID, Quantity: 2, PriceAtAdd: 40})
+		carts = append(carts, cart)
+	}
+
+	// Recent carts are left alone
+	sent, err := services.SendCartReminders(db, emails, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// Abandoned carts are reminded about, except when opted out
+	age(2 * time.Hour)
+	sent, _ = services.SendCartReminders(db, emails, config)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"cart:buyer@example.com:2"}, emails.alerts)
+
+	// Not again until the interval passed, and never beyond the cap
+	sent, _ = services.SendCartReminders(db, emails, config)
+	assert.Equal(t, 0, sent)
+	for i := 0; i < 3; i++ {
+		db.Model(&models.CartReminder{}).Where("1 = 1").Update("sent_at", time.Now().Add(-90*time.Minute))
+		services.SendCartReminders(db, emails, config)
+	}
+	assert.Len(t, emails.alerts, 2)
+
+	// Ordering the cart records the conversion on its open reminders
+	order := models.Order{UserID: buyer.ID, TotalAmount: 80, ShippingAddress: "1 Main St"}
+	db.Create(&order)
+	assert.NoError(t, services.RecordCartConversion(db, carts[0].ID, order.ID))
+
+	var converted int64
+	db.Model(&models.CartReminder{}).Where("cart_id = ? AND converted_at IS NOT NULL AND order_id = ?", carts[0].ID, order.ID).Count(&converted)
+	assert.Equal(t, int64(2), converted)
+}
+
+func TestVerifiedPurchaseReviews(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	visitor := createUser(db, "visitor")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	order := models.Order{UserID: buyer.ID, Status: models.OrderStatusShipped, TotalAmount: 40, ShippingAddress: "1 Main St"}
+	db.Create(&order)
+	db.Create(&models.OrderItem{OrderID: order.ID, ProductID: lamp.ID, Quantity: 1, Price: 40})
+
+	newRouter := func(reviewHandler *handlers.ReviewHandler) *gin.Engine {
+		router := newTestRouter()
+		router.POST("/reviews", reviewHandler.CreateReview)
+		router.GET("/reviews/product/:id", reviewHandler.GetProductReviews)
+		return router
+	}
+	review := map[string]interface{}{"product_id": lamp.ID, "rating": 5, "comment": "Bright"}
+
+	// Purchases are required by default and have to be delivered
+	router := newRouter(handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor()))
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "POST", "/reviews", seller.ID, review).Code)
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "POST", "/reviews", buyer.ID, review).Code)
+	db.Model(&order).Update("status", models.OrderStatusDelivered)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/reviews", buyer.ID, review).Code)
+
+	// Without the requirement anyone but the seller can review, unverified
+	t.Setenv("REVIEWS_REQUIRE_PURCHASE", "false")
+	router = newRouter(handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor()))
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "POST", "/reviews", seller.ID, review).Code)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/reviews", visitor.ID, review).Code)
+
+	var response struct {
+		Reviews []models.Review `json:"reviews"`
+	}
+	json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d?verified=true", lamp.ID), buyer.ID, nil).Body.Bytes(), &response)
+	if assert.Len(t, response.Reviews, 1) {
+		assert.Equal(t, buyer.ID, response.Reviews[0].UserID)
+		assert.True(t, response.Reviews[0].VerifiedPurchase)
+	}
+	json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d", lamp.ID), buyer.ID, nil).Body.Bytes(), &response)
+	assert.Len(t, response.Reviews, 2)
+}
+
+func TestProductRatingAggregates(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("REVIEWS_REQUIRE_PURCHASE", "false")
+	db := setupTestDB()
+	reviewHandler := handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor())
+
+	seller := createUser(db, "seller")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	var reviewers []models.User
+	for i := 0; i < 3; i++ {
+		user := createUser(db, fmt.Sprintf("reviewer%d", i))
+		reviewers = append(reviewers, user)
+	}
+
+	router := newTestRouter()
+	router.POST("/reviews", reviewHandler.CreateReview)
+	router.PUT("/reviews/:id", reviewHandler.UpdateReview)
+	router.DELETE("/reviews/:id", reviewHandler.DeleteReview)
+	router.GET("/reviews/product/:id/summary", reviewHandler.GetProductReviewSummary)
+
+	type summary struct {
+		AverageRating float64        `json:"average_rating"`
+		ReviewCount   int            `json:"review_count"`
+		Histogram     map[string]int `json:"histogram"`
+	}
+	getSummary := func() summary {
+		var response summary
+		json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d/summary", lamp.ID), seller.ID, nil).Body.Bytes(), &response)
+		return response
+	}
+
+	var reviewIDs []uint
+	for i, rating := range []int{5, 4, 1} {
+		w := doJSON(router, "POST", "/reviews", reviewers[i].ID, map[string]interface{}{"product_id": lamp.ID, "rating": rating})
+		var response struct {
+			Review models.Review `json:"review"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		reviewIDs = append(reviewIDs, response.Review.ID)
+	}
+	result := getSummary()
+	assert.Equal(t, 3, result.ReviewCount)
+	assert.InDelta(t, 10.0/3, result.AverageRating, 0.001)
+	assert.Equal(t, map[string]int{"1": 1, "2": 0, "3": 0, "4": 1, "5": 1}, result.Histogram)
+
+	// Changing and deleting reviews moves the counts
+	doJSON(router, "PUT", fmt.Sprintf("/reviews/%d", reviewIDs[2]), reviewers[2].ID, map[string]interface{}{"rating": 3})
+	doJSON(router, "DELETE", fmt.Sprintf("/reviews/%d", reviewIDs[0]), reviewers[0].ID, nil)
+	result = getSummary()
+	assert.Equal(t, 2, result.ReviewCount)
+	assert.InDelta(t, 3.5, result.AverageRating, 0.001)
+	assert.Equal(t, map[string]int{"1": 0, "2": 0, "3": 1, "4": 1, "5": 0}, result.Histogram)
+
+	// Rebuilding from the reviews gives the same aggregates
+	db.Model(&lamp).UpdateColumns(map[string]interface{}{"review_count": 9, "average_rating": 1, "rating5_count": 9})
+	assert.NoError(t, services.RecalculateProductRatings(db))
+	assert.Equal(t, result, getSummary())
+}
+
+func TestReviewVotesAndSorting(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	reviewHandler := handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor())
+
+	seller := createUser(db, "seller")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	var users []models.User
+	var reviews []models.Review
+	for i, rating := range []int{5, 2, 4} {
+		user := createUser(db, fmt.Sprintf("user%d", i))
+		users = append(users, user)
+		review := models.Review{UserID: user.ID, ProductID: lamp.ID, Rating: rating}
+		db.Create(&review)
+		reviews = append(reviews, review)
+	}
+
+	router := newTestRouter()
+	router.GET("/reviews/product/:id", reviewHandler.GetProductReviews)
+	router.POST("/reviews/:id/vote", reviewHandler.VoteReview)
+	router.DELETE("/reviews/:id/vote", reviewHandler.DeleteReviewVote)
+
+	vote := func(review models.Review, user models.User, helpful bool) int {
+		return doJSON(router, "POST", fmt.Sprintf("/reviews/%d/vote", review.ID), user.ID, map[string]interface{}{"helpful": helpful}).Code
+	}
+	list := func(query string) []uint {
+		var response struct {
+			Reviews []models.Review `json:"reviews"`
+		}
+		json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d?%s", lamp.ID, query), seller.ID, nil).Body.Bytes(), &response)
+		ids := []uint{}
+		for _, review := range response.Reviews {
+			ids = append(ids, review.ID)
+		}
+		return ids
+	}
+
+	// Authors cannot vote on their own reviews, others vote once each
+	assert.Equal(t, http.StatusForbidden, vote(reviews[1], users[1], true))
+	assert.Equal(t, http.StatusOK, vote(reviews[1], users[0], true))
+	assert.Equal(t, http.StatusOK, vote(reviews[1], users[0], true))
+	assert.Equal(t, http.StatusOK, vote(reviews[1], users[2], true))
+	assert.Equal(t, http.StatusOK, vote(reviews[2], users[0], false))
+	assert.Equal(t, http.StatusOK, vote(reviews[2], users[0], true))
+	assert.Equal(t, http.StatusOK, vote(reviews[0], seller, false))
+
+	counts := func(review models.Review) (int, int) {
+		var current models.Review
+		db.First(&current, review.ID)
+		return current.HelpfulCount, current.UnhelpfulCount
+	}
+	helpful, unhelpful := counts(reviews[1])
+	assert.Equal(t, []int{2, 0}, []int{helpful, unhelpful})
+	helpful, unhelpful = counts(reviews[2])
+	assert.Equal(t, []int{1, 0}, []int{helpful, unhelpful})
+
+	assert.Equal(t, []uint{reviews[1].ID, reviews[2].ID, reviews[0].ID}, list("sort=helpful"))
+	assert.Equal(t, []uint{reviews[0].ID, reviews[2].ID, reviews[1].ID}, list("sort=highest"))
+	assert.Equal(t, []uint{reviews[1].ID, reviews[2].ID, reviews[0].ID}, list("sort=lowest"))
+	assert.Equal(t, []uint{reviews[2].ID, reviews[0].ID}, list("sort=lowest&rating=4,5"))
+	assert.Equal(t, []uint{reviews[1].ID}, list("sort=helpful&limit=1"))
+
+	// Withdrawing a vote lowers the count
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", fmt.Sprintf("/reviews/%d/vote", reviews[1].ID), users[0].ID, nil).Code)
+	helpful, _ = counts(reviews[1])
+	assert.Equal(t, 1, helpful)
+}
+
+func TestSellerReviewResponses(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("MODERATION_BANNED_WORDS", "scam")
+	db := setupTestDB()
+	emails := &recordingEmailService{}
+	reviewHandler := handlers.NewReviewHandler(db, emails, nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor())
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	review := models.Review{UserID: buyer.ID, ProductID: lamp.ID, Rating: 2, Comment: "Flickers"}
+	db.Create(&review)
+
+	router := newTestRouter()
+	router.GET("/reviews/product/:id", reviewHandler.GetProductReviews)
+	router.PUT("/reviews/:id/response", reviewHandler.RespondToReview)
+
+	responseURL := fmt.Sprintf("/reviews/%d/response", review.ID)
+
+	// Only the seller responds, the reviewer is told once
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "PUT", responseURL, buyer.ID, map[string]interface{}{"body": "Me too"}).Code)
+	// Responses go through the same banned-word filter as reviews
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "PUT", responseURL, seller.ID, map[string]interface{}{"body": "This review is a scam"}).Code)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "PUT", responseURL, seller.ID, map[string]interface{}{"body": "Sorry, we will replace it"}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", responseURL, seller.ID, map[string]interface{}{"body": "Sorry, a replacement is on its way"}).Code)
+	assert.Equal(t, []string{"response:buyer@example.com:Sorry, we will replace it"}, emails.alerts)
+
+	var listed struct {
+		Reviews []models.Review `json:"reviews"`
+	}
+	json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d", lamp.ID), buyer.ID, nil).Body.Bytes(), &listed)
+	if assert.Len(t, listed.Reviews, 1) && assert.NotNil(t, listed.Reviews[0].Response) {
+		assert.Equal(t, "Sorry, a replacement is on its way", listed.Reviews[0].Response.Body)
+	}
+}
+
+func TestContentModeration(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("REVIEWS_REQUIRE_PURCHASE", "false")
+	t.Setenv("MODERATION_AUTO_HIDE_REPORTS", "2")
+	t.Setenv("MODERATION_BANNED_WORDS", "scam, junk")
+	db := setupTestDB()
+	reviewHandler := handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor())
+	moderationHandler := handlers.NewModerationHandler(db, services.NewLocalStorage(t.TempDir(), "/uploads"))
+
+	admin := createUser(db, "admin")
+	db.Model(&admin).Update("is_admin", true)
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	var reporters []models.User
+	for i := 0; i < 2; i++ {
+		user := createUser(db, fmt.Sprintf("reporter%d", i))
+		reporters = append(reporters, user)
+	}
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+
+	router := newTestRouter()
+	router.POST("/reviews", reviewHandler.CreateReview)
+	router.GET("/reviews/product/:id", reviewHandler.GetProductReviews)
+	router.POST("/reports", moderationHandler.ReportContent)
+	router.GET("/admin/moderation", moderationHandler.GetModerationQueue)
+	router.GET("/admin/moderation/log", moderationHandler.GetModerationLog)
+	router.POST("/admin/moderation/:type/:id", moderationHandler.ModerateContent)
+
+	listedReviews := func() int {
+		var response struct {
+			Reviews []models.Review `json:"reviews"`
+		}
+		json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d", lamp.ID), buyer.ID, nil).Body.Bytes(), &response)
+		return len(response.Reviews)
+	}
+	reviewCount := func() int {
+		var product models.Product
+		db.First(&product, lamp.ID)
+		return product.ReviewCount
+	}
+
+	// Banned words are rejected
+	w := doJSON(router, "POST", "/reviews", buyer.ID, map[string]interface{}{"product_id": lamp.ID, "rating": 1, "comment": "Total SCAM!"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doJSON(router, "POST", "/reviews", buyer.ID, map[string]interface{}{"product_id": lamp.ID, "rating": 1, "comment": "Broke in a week"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		Review models.Review `json:"review"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &created)
+	report := map[string]interface{}{"content_type": "review", "content_id": created.Review.ID, "reason": "Misleading"}
+
+	// Authors cannot report themselves and reporters report once
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "POST", "/reports", buyer.ID, report).Code)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/reports", reporters[0].ID, report).Code)
+	assert.Equal(t, http.StatusConflict, doJSON(router, "POST", "/reports", reporters[0].ID, report).Code)
+	assert.Equal(t, 1, listedReviews())
+
+	// The second report hides the review and takes it out of the rating
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/reports", reporters[1].ID, report).Code)
+	assert.Equal(t, 0, listedReviews())
+	assert.Equal(t, 0, reviewCount())
+
+	var queue struct {
+		Items []struct {
+			ContentID   uint `json:"content_id"`
+			ReportCount int  `json:"report_count"`
+			Hidden      bool `json:"hidden"`
+		} `json:"items"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/admin/moderation", admin.ID, nil).Body.Bytes(), &queue)
+	if assert.Len(t, queue.Items, 1) {
+		assert.Equal(t, created.Review.ID, queue.Items[0].ContentID)
+		assert.Equal(t, 2, queue.Items[0].ReportCount)
+		assert.True(t, queue.Items[0].Hidden)
+	}
+
+	// Approving shows the review again and empties the queue
+	moderateURL := fmt.Sprintf("/admin/moderation/review/%d", created.Review.ID)
+	assert.Equal(t, http.StatusOK, doJSON(router, "POST", moderateURL, admin.ID, map[string]interface{}{"action": "approve", "reason": "Honest review"}).Code)
+	assert.Equal(t, 1, listedReviews())
+	assert.Equal(t, 1, reviewCount())
+	json.Unmarshal(doJSON(router, "GET", "/admin/moderation", admin.ID, nil).Body.Bytes(), &queue)
+	assert.Empty(t, queue.Items)
+
+	// Products are moderated the same way
+	productReport := map[string]interface{}{"content_type": "product", "content_id": lamp.ID, "reason": "Counterfeit"}
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/reports", buyer.ID, productReport).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "POST", fmt.Sprintf("/admin/moderation/product/%d", lamp.ID), admin.ID, map[string]interface{}{"action": "delete"}).Code)
+	assert.ErrorIs(t, db.First(&models.Product{}, lamp.ID).Error, gorm.ErrRecordNotFound)
+
+	// Every decision is in the audit trail, newest first
+	var log struct {
+		Actions []models.ModerationAction `json:"actions"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/admin/moderation/log", admin.ID, nil).Body.Bytes(), &log)
+	if assert.Len(t, log.Actions, 3) {
+		assert.Equal(t, models.ModerationDelete, log.Actions[0].Action)
+		assert.Equal(t, 1, log.Actions[0].ReportCount)
+		assert.Equal(t, models.ModerationApprove, log.Actions[1].Action)
+		assert.Equal(t, 2, log.Actions[1].ReportCount)
+		assert.Equal(t, models.ModerationAutoHide, log.Actions[2].Action)
+		assert.Nil(t, log.Actions[2].ModeratorID)
+	}
+}
+
+func TestModeratorProductDeletion(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	storage := services.NewLocalStorage(t.TempDir(), "/uploads")
+	moderationHandler := handlers.NewModerationHandler(db, storage)
+
+	admin := createUser(db, "admin")
+	db.Model(&admin).Update("is_admin", true)
+	seller := createUser(db, "seller")
+	sku := "LAMP-1"
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, SKU: &sku, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	storage.Put("products/lamp.jpg", []byte("image"), "image/jpeg")
+	storage.Put("products/lamp-thumb.jpg", []byte("thumbnail"), "image/jpeg")
+	db.Create(&models.ProductImage{ProductID: lamp.ID, URL: "/uploads/products/lamp.jpg", StorageKey: "products/lamp.jpg", ThumbnailKey: "products/lamp-thumb.jpg"})
+
+	router := newTestRouter()
+	router.POST("/admin/moderation/:type/:id", moderationHandler.ModerateContent)
+
+	w := doJSON(router, "POST", fmt.Sprintf("/admin/moderation/product/%d", lamp.ID), admin.ID, gin.H{"action": "delete", "reason": "Counterfeit"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Deleting on behalf of the seller releases the SKU and removes the images
+	var deleted models.Product
+	db.Unscoped().First(&deleted, lamp.ID)
+	assert.True(t, deleted.DeletedAt.Valid)
+	assert.Nil(t, deleted.SKU)
+
+	var images int64
+	db.Unscoped().Model(&models.ProductImage{}).Where("product_id = ?", lamp.ID).Count(&images)
+	assert.Equal(t, int64(0), images)
+	for _, key := range []string{"products/lamp.jpg", "products/lamp-thumb.jpg"} {
+		_, _, err := storage.Get(key)
+		assert.Equal(t, services.ErrObjectNotFound, err, key)
+	}
+
+	reused := models.Product{Name: "Lamp", Price: 40, SKU: &sku, UserID: seller.ID, IsActive: true}
+	assert.NoError(t, db.Create(&reused).Error)
+}
+
+func TestReviewPhotos(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("REVIEWS_REQUIRE_PURCHASE", "false")
+	t.Setenv("REVIEW_MAX_PHOTOS", "2")
+	db := setupTestDB()
+	uploadDir := t.TempDir()
+	reviewHandler := handlers.NewReviewHandler(db, services.NewMockEmailService(), nil, services.NewLocalStorage(uploadDir, "/uploads"), services.NewImageProcessor())
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	withPhotos := models.Review{UserID: buyer.ID, ProductID: lamp.ID, Rating: 5, Comment: "Looks great"}
+	db.Create(&withPhotos)
+	textOnly := models.Review{UserID: seller.ID, ProductID: lamp.ID, Rating: 4, Comment: "Nice"}
+	db.Create(&textOnly)
+
+	router := newTestRouter()
+	router.GET("/reviews/product/:id", reviewHandler.GetProductReviews)
+	router.DELETE("/reviews/:id", reviewHandler.DeleteReview)
+	router.POST("/reviews/:id/photos", reviewHandler.UploadReviewPhotos)
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+	upload := func(userID uint, count int) *httptest.ResponseRecorder {
+		files := make(map[string][]byte)
+		for i := 0; i < count; i++ {
+			files[fmt.Sprintf("photo%d.png", i)] = encoded.Bytes()
+		}
+		return doMultipart(router, fmt.Sprintf("/reviews/%d/photos", withPhotos.ID), userID, nil, "photos", files)
+	}
+	list := func(query string) []models.Review {
+		var response struct {
+			Reviews []models.Review `json:"reviews"`
+		}
+		json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/reviews/product/%d%s", lamp.ID, query), buyer.ID, nil).Body.Bytes(), &response)
+		return response.Reviews
+	}
+
+	// Only the author attaches photos, up to the configured number
+	assert.Equal(t, http.StatusNotFound, upload(seller.ID, 1).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(buyer.ID, 3).Code)
+	assert.Equal(t, http.StatusCreated, upload(buyer.ID, 2).Code)
+	assert.Equal(t, http.StatusBadRequest, upload(buyer.ID, 1).Code)
+
+	// Photos are listed with the review and can be filtered on
+	assert.Len(t, list(""), 2)
+	filtered := list("?with_photos=true")
+	if assert.Len(t, filtered, 1) && assert.Len(t, filtered[0].Photos, 2) {
+		assert.Equal(t, 640, filtered[0].Photos[0].Width)
+		assert.NotEmpty(t, filtered[0].Photos[0].ThumbnailURL)
+	}
+
+	// Deleting the review removes its photos
+	var photos []models.ReviewPhoto
+	db.Find(&photos)
+	w := doJSON(router, "DELETE", fmt.Sprintf("/reviews/%d", withPhotos.ID), buyer.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var remaining int64
+	db.Model(&models.ReviewPhoto{}).Count(&remaining)
+	assert.Zero(t, remaining)
+	for _, photo := range photos {
+		_, err := os.Stat(filepath.Join(uploadDir, filepath.FromSlash(photo.StorageKey)))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestConversationPaging(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	messageHandler := handlers.NewMessageHandler(db, nil, services.NewLocalStorage(t.TempDir(), "/uploads"))
+
+	alice := createUser(db, "alice")
+	bob := createUser(db, "bob")
+	var ids []uint
+	for i := 0; i < 5; i++ {
+		message := models.Message{FromUserID: bob.ID, ToUserID: alice.ID, Content: fmt.Sprintf("message %d", i)}
+		if i%2 == 1 {
+			message.FromUserID, message.ToUserID = alice.ID, bob.ID
+		}
+		db.Create(&message)
+		ids = append(ids, message.ID)
+	}
+
+	router := newTestRouter()
+	router.GET("/messages/conversation/:user_id", messageHandler.GetConversation)
+	router.PUT("/messages/conversation/:user_id/read", messageHandler.MarkConversationRead)
+
+	type page struct {
+		Messages   []models.Message `json:"messages"`
+		Pagination struct {
+			HasOlder bool `json:"has_older"`
+			HasNewer bool `json:"has_newer"`
+		} `json:"pagination"`
+	}
+	fetch := func(query string) (int, page) {
+		w := doJSON(router, "GET", fmt.Sprintf("/messages/conversation/%d%s", bob.ID, query), alice.ID, nil)
+		var response page
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+	messageIDs := func(p page) []uint {
+		var result []uint
+		for _, message := range p.Messages {
+			result = append(result, message.ID)
+		}
+		return result
+	}
+
+	// The latest messages come first, oldest to newest within the page
+	code, latest := fetch("?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, ids[3:], messageIDs(latest))
+	assert.True(t, latest.Pagination.HasOlder)
+	assert.False(t, latest.Pagination.HasNewer)
+
+	_, older := fetch(fmt.Sprintf("?limit=2&before=%d", ids[3]))
+	assert.Equal(t, ids[1:3], messageIDs(older))
+	assert.True(t, older.Pagination.HasOlder)
+	assert.True(t, older.Pagination.HasNewer)
+
+	_, newer := fetch(fmt.Sprintf("?after=%d", ids[2]))
+	assert.Equal(t, ids[3:], messageIDs(newer))
+	assert.True(t, newer.Pagination.HasOlder)
+	assert.False(t, newer.Pagination.HasNewer)
+
+	code, _ = fetch(fmt.Sprintf("?before=%d&after=%d", ids[3], ids[1]))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Fetching leaves messages unread until the client marks them
+	var unread int64
+	db.Model(&models.Message{}).Where("to_user_id = ? AND is_read = ?", alice.ID, false).Count(&unread)
+	assert.Equal(t, int64(3), unread)
+
+	w := doJSON(router, "PUT", fmt.Sprintf("/messages/conversation/%d/read", bob.ID), alice.ID, gin.H{"up_to_message_id": ids[2]})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"marked": 2}`, w.Body.String())
+	db.Model(&models.Message{}).Where("to_user_id = ? AND is_read = ?", alice.ID, false).Count(&unread)
+	assert.Equal(t, int64(1), unread)
+}
+
+func TestProductAndOrderConversations(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	db := setupTestDB()
+	messageHandler := handlers.NewMessageHandler(db, nil, services.NewLocalStorage(t.TempDir(), "/uploads"))
+
+	seller := createUser(db, "seller")
+	buyer := createUser(db, "buyer")
+	stranger := createUser(db, "stranger")
+	lamp := models.Product{Name: "Lamp", Price: 40, Stock: 5, UserID: seller.ID, IsActive: true}
+	db.Create(&lamp)
+	otherLamp := models.Product{Name: "Other lamp", Price: 30, Stock: 5, UserID: stranger.ID, IsActive: true}
+	db.Create(&otherLamp)
+	order := models.Order{UserID: buyer.ID, Status: models.OrderStatusDelivered, TotalAmount: 40, ShippingAddress: "1 Main St"}
+	db.Create(&order)
+	db.Create(&models.OrderItem{OrderID: order.ID, ProductID: lamp.ID, Quantity: 1, Price: 40})
+
+	router := newTestRouter()
+	router.POST("/messages", messageHandler.SendMessage)
+	router.GET("/messages/conversations", messageHandler.GetConversations)
+	router.GET("/messages/conversations/:id", messageHandler.GetConversationMessages)
+
+	message := func(userID uint, body map[string]interface{}) models.Message {
+		w := doJSON(router, "POST", "/messages", userID, body)
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var response struct {
+			Message models.Message `json:"message"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Message
+	}
+	type inbox struct {
+		Conversations []struct {
+			ID          uint            `json:"id"`
+			ProductID   *uint           `json:"product_id"`
+			OrderID     *uint           `json:"order_id"`
+			LastMessage *models.Message `json:"last_message"`
+			UnreadCount int             `json:"unread_count"`
+		} `json:"conversations"`
+	}
+	getInbox := func(userID uint, query string) inbox {
+		var response inbox
+		json.Unmarshal(doJSON(router, "GET", "/messages/conversations"+query, userID, nil).Body.Bytes(), &response)
+		return response
+	}
+
+	// Questions about a product, an order and nothing in particular are kept apart
+	aboutLamp := message(buyer.ID, map[string]interface{}{"to_user_id": seller.ID, "product_id": lamp.ID, "content": "Is it dimmable?"})
+	general := message(buyer.ID, map[string]interface{}{"to_user_id": seller.ID, "content": "Hello"})
+	aboutOrder := message(buyer.ID, map[string]interface{}{"to_user_id": seller.ID, "order_id": order.ID, "content": "Where is my order?"})
+	again := message(buyer.ID, map[string]interface{}{"to_user_id": seller.ID, "product_id": lamp.ID, "content": "Which bulb does it take?"})
+	assert.Equal(t, aboutLamp.ConversationID, again.ConversationID)
+	assert.NotEqual(t, aboutLamp.ConversationID, general.ConversationID)
+	assert.NotEqual(t, aboutLamp.ConversationID, aboutOrder.ConversationID)
+
+	// The product or order must be between the participants
+	w := doJSON(router, "POST", "/messages", buyer.ID, map[string]interface{}{"to_user_id": seller.ID, "product_id": otherLamp.ID, "content": "Hi"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = doJSON(router, "POST", "/messages", stranger.ID, map[string]interface{}{"to_user_id": seller.ID, "order_id": order.ID, "content": "Hi"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// The seller's inbox is grouped by conversation and can be filtered
+	all := getInbox(seller.ID, "")
+	assert.Len(t, all.Conversations, 3)
+	filtered := getInbox(seller.ID, fmt.Sprintf("?product_id=%d", lamp.ID))
+	if assert.Len(t, filtered.Conversations, 1) {
+		assert.Equal(t, aboutLamp.ConversationID, filtered.Conversations[0].ID)
+		assert.Equal(t, 2, filtered.Conversations[0].UnreadCount)
+		assert.Equal(t, "Which bulb does it take?", filtered.Conversations[0].LastMessage.Content)
+	}
+	filtered = getInbox(seller.ID, fmt.Sprintf("?order_id=%d", order.ID))
+	if assert.Len(t, filtered.Conversations, 1) {
+		assert.Equal(t, aboutOrder.ConversationID, filtered.Conversations[0].ID)
+	}
+
+	// Replies go to the other participant, outsiders cannot see or post
+	reply := message(seller.ID, map[string]interface{}{"conversation_id": aboutLamp.ConversationID, "content": "Yes, any E27 bulb"})
+	assert.Equal(t, buyer.ID, reply.ToUserID)
+	conversationURL := fmt.Sprintf("/messages/conversations/%d", aboutLamp.ConversationID)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "POST", "/messages", stranger.ID, map[string]interface{}{"conversation_id": aboutLamp.ConversationID, "content": "Hi"}).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", conversationURL, stranger.ID, nil).Code)
+
+	var thread struct {
+		Conversation models.Conversation `json:"conversation"`
+		Messages     []models.Message    `json:"messages"`
+	}
+	json.Unmarshal(doJSON(router, "GET", conversationURL, buyer.ID, nil).Body.Bytes(), &thread)
+	assert.Len(t, thread.Messages, 3)
+	assert.Len(t, thread.Conversation.Participants, 2)
+	if assert.NotNil(t, thread.Conversation.Product) {
+		assert.Equal(t, "Lamp", thread.Conversation.Product.Name)
+	}
+}
+
+func TestMessageAttachments(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("MESSAGE_MAX_ATTACHMENTS", "2")
+	db := setupTestDB()
+	storage := services.NewLocalStorage(t.TempDir(), "/uploads")
+	messageHandler := handlers.NewMessageHandler(db, nil, storage)
+	uploadHandler := handlers.NewUploadHandler(storage)
+
+	alice := createUser(db, "alice")
+	bob := createUser(db, "bob")
+	eve := createUser(db, "eve")
+
+	router := newTestRouter()
+	router.POST("/messages", messageHandler.SendMessage)
+	router.GET("/messages/conversation/:user_id", messageHandler.GetConversation)
+	router.GET("/messages/attachments/:id", messageHandler.DownloadAttachment)
+	router.GET("/uploads/*key", uploadHandler.ServeUpload)
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 20, 20)))
+	pdf := []byte("%PDF-1.4\n1 0 obj << >> endobj\ntrailer << >>\n%%EOF\n")
+
+	sendFiles := func(files map[string][]byte) *httptest.ResponseRecorder {
+		fields := map[string]string{"to_user_id": strconv.Itoa(int(bob.ID)), "content": "Here are the details"}
+		return doMultipart(router, "/messages", alice.ID, fields, "attachments", files)
+	}
+	get := func(url string, userID uint) *httptest.ResponseRecorder {
+		return doJSON(router, "GET", url, userID, nil)
+	}
+
+	// Only images and PDFs are accepted, up to the configured number
+	assert.Equal(t, http.StatusBadRequest, sendFiles(map[string][]byte{"notes.txt": []byte("plain text")}).Code)
+	assert.Equal(t, http.StatusBadRequest, sendFiles(map[string][]byte{"a.png": encoded.Bytes(), "b.png": encoded.Bytes(), "c.pdf": pdf}).Code)
+	w := sendFiles(map[string][]byte{"photo.png": encoded.Bytes(), "invoice.pdf": pdf})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// The metadata comes with the conversation
+	var conversation struct {
+		Messages []models.Message `json:"messages"`
+	}
+	json.Unmarshal(get(fmt.Sprintf("/messages/conversation/%d", alice.ID), bob.ID).Body.Bytes(), &conversation)
+	if !assert.Len(t, conversation.Messages, 1) || !assert.Len(t, conversation.Messages[0].Attachments, 2) {
+		return
+	}
+	var invoice models.MessageAttachment
+	for _, attachment := range conversation.Messages[0].Attachments {
+		if attachment.FileName == "invoice.pdf" {
+			invoice = attachment
+		}
+	}
+	assert.Equal(t, "application/pdf", invoice.ContentType)
+
+	// Only participants download, and never through the public uploads
+	downloadURL := fmt.Sprintf("/messages/attachments/%d", invoice.ID)
+	w = get(downloadURL, bob.ID)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, pdf, w.Body.Bytes())
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename=invoice.pdf`)
+	assert.Equal(t, http.StatusOK, get(downloadURL, alice.ID).Code)
+	assert.Equal(t, http.StatusNotFound, get(downloadURL, eve.ID).Code)
+
+	var stored models.MessageAttachment
+	db.First(&stored, invoice.ID)
+	assert.Equal(t, http.StatusNotFound, get("/uploads/"+stored.StorageKey, bob.ID).Code)
+}
+
+func TestMessagingBlocksMutesAndStrangerLimit(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("MESSAGE_STRANGER_LIMIT", "2")
+	db := setupTestDB()
+	messageHandler := handlers.NewMessageHandler(db, nil, services.NewLocalStorage(t.TempDir(), "/uploads"))
+
+	users := make([]models.User, 5)
+	for i, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		users[i] = createUser(db, name)
+	}
+	alice, bob, carol, dave, erin := users[0], users[1], users[2], users[3], users[4]
+
+	router := newTestRouter()
+	router.POST("/messages", messageHandler.SendMessage)
+	router.GET("/messages/conversations", messageHandler.GetConversations)
+	router.PUT("/messages/conversations/:id/mute", messageHandler.MuteConversation)
+	router.DELETE("/messages/conversations/:id/mute", messageHandler.UnmuteConversation)
+	router.GET("/messages/unread-count", messageHandler.GetUnreadCount)
+	router.GET("/messages/blocks", messageHandler.GetBlockedUsers)
+	router.POST("/messages/blocks", messageHandler.BlockUser)
+	router.DELETE("/messages/blocks/:user_id", messageHandler.UnblockUser)
+
+	send := func(from, to uint) *httptest.ResponseRecorder {
+		return doJSON(router, "POST", "/messages", from, gin.H{"to_user_id": to, "content": "Hello"})
+	}
+	inbox := func(userID uint) []map[string]interface{} {
+		var resp struct {
+			Conversations []map[string]interface{} `json:"conversations"`
+		}
+		json.Unmarshal(doJSON(router, "GET", "/messages/conversations", userID, nil).Body.Bytes(), &resp)
+		return resp.Conversations
+	}
+
+	// Only two unanswered conversations with strangers per hour
+	assert.Equal(t, http.StatusCreated, send(alice.ID, bob.ID).Code)
+	assert.Equal(t, http.StatusCreated, send(alice.ID, carol.ID).Code)
+	assert.Equal(t, http.StatusTooManyRequests, send(alice.ID, dave.ID).Code)
+	// Existing conversations are not limited, and a reply frees a slot
+	assert.Equal(t, http.StatusCreated, send(alice.ID, bob.ID).Code)
+	assert.Equal(t, http.StatusCreated, send(bob.ID, alice.ID).Code)
+	assert.Equal(t, http.StatusCreated, send(alice.ID, dave.ID).Code)
+
+	// Blocking rejects messages both ways and hides the conversation
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "POST", "/messages/blocks", bob.ID, gin.H{"user_id": bob.ID}).Code)
+	assert.Equal(t, http.StatusCreated, doJSON(router, "POST", "/messages/blocks", bob.ID, gin.H{"user_id": alice.ID}).Code)
+	assert.Equal(t, http.StatusConflict, doJSON(router, "POST", "/messages/blocks", bob.ID, gin.H{"user_id": alice.ID}).Code)
+	assert.Equal(t, http.StatusForbidden, send(alice.ID, bob.ID).Code)
+	assert.Equal(t, http.StatusForbidden, send(bob.ID, alice.ID).Code)
+	assert.Len(t, inbox(bob.ID), 0)
+	assert.Len(t, inbox(alice.ID), 3)
+
+	var unread struct {
+		UnreadCount int64 `json:"unread_count"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/messages/unread-count", bob.ID, nil).Body.Bytes(), &unread)
+	assert.Equal(t, int64(0), unread.UnreadCount)
+
+	var blocks struct {
+		Blocks []models.UserBlock `json:"blocks"`
+	}
+	json.Unmarshal(doJSON(router, "GET", "/messages/blocks", bob.ID, nil).Body.Bytes(), &blocks)
+	if assert.Len(t, blocks.Blocks, 1) {
+		assert.Equal(t, "alice", blocks.Blocks[0].BlockedUser.FirstName)
+	}
+
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", fmt.Sprintf("/messages/blocks/%d", alice.ID), bob.ID, nil).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "DELETE", fmt.Sprintf("/messages/blocks/%d", alice.ID), bob.ID, nil).Code)
+	assert.Equal(t, http.StatusCreated, send(alice.ID, bob.ID).Code)
+	assert.Len(t, inbox(bob.ID), 1)
+
+	// Muting is per participant and only for participants
+	conversationID := uint(inbox(bob.ID)[0]["id"].(float64))
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "PUT", fmt.Sprintf("/messages/conversations/%d/mute", conversationID), erin.ID, nil).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", fmt.Sprintf("/messages/conversations/%d/mute", conversationID), bob.ID, nil).Code)
+	assert.Equal(t, true, inbox(bob.ID)[0]["muted"])
+	for _, conversation := range inbox(alice.ID) {
+		assert.Equal(t, false, conversation["muted"])
+	}
+	muted, err := services.IsConversationMuted(db, conversationID, bob.ID)
+	assert.NoError(t, err)
+	assert.True(t, muted)
+
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", fmt.Sprintf("/messages/conversations/%d/mute", conversationID), bob.ID, nil).Code)
+	assert.Equal(t, false, inbox(bob.ID)[0]["muted"])
+}
+
+func TestMessageEditAndDelete(t *testing.T) {
+	// Setup
+	gin.SetMode(gin.TestMode)
+	t.Setenv("MESSAGE_EDIT_WINDOW_MINUTES", "10")
+	db := setupTestDB()
+	storage := services.NewLocalStorage(t.TempDir(), "/uploads")
+	messageHandler := handlers.NewMessageHandler(db, nil, storage)
+
+	alice := createUser(db, "alice")
+	bob := createUser(db, "bob")
+	eve := createUser(db, "eve")
+
+	router := newTestRouter()
+	router.POST("/messages", messageHandler.SendMessage)
+	router.GET("/messages/conversation/:user_id", messageHandler.GetConversation)
+	router.GET("/messages/attachments/:id", messageHandler.DownloadAttachment)
+	router.PUT("/messages/:id", messageHandler.EditMessage)
+	router.GET("/messages/:id/edits", messageHandler.GetMessageEdits)
+	router.DELETE("/messages/:id", messageHandler.DeleteMessage)
+
+	send := func(content string) models.Message {
+		var resp struct {
+			Message models.Message `json:"message"`
+		}
+		w := doJSON(router, "POST", "/messages", alice.ID, gin.H{"to_user_id": bob.ID, "content": content})
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Message
+	}
+	conversation := func(userID, otherID uint) []models.Message {
+		var resp struct {
+			Messages []models.Message `json:"messages"`
+		}
+		json.Unmarshal(doJSON(router, "GET", fmt.Sprintf("/messages/conversation/%d", otherID), userID, nil).Body.Bytes(), &resp)
+		return resp.Messages
+	}
+
+	// Only the sender edits, within the window, and the history is kept
+	first := send("See you at 5")
+	url := fmt.Sprintf("/messages/%d", first.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "PUT", url, bob.ID, gin.H{"content": "Changed"}).Code)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "PUT", url, eve.ID, gin.H{"content": "Changed"}).Code)
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "PUT", url, alice.ID, gin.H{"content": "See you at 5"}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", url, alice.ID, gin.H{"content": "See you at 6"}).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "PUT", url, alice.ID, gin.H{"content": "See you at 7"}).Code)
+
+	messages := conversation(bob.ID, alice.ID)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "See you at 7", messages[0].Content)
+		assert.NotNil(t, messages[0].EditedAt)
+	}
+	var history struct {
+		Edits []models.MessageEdit `json:"edits"`
+	}
+	json.Unmarshal(doJSON(router, "GET", url+"/edits", bob.ID, nil).Body.Bytes(), &history)
+	if assert.Len(t, history.Edits, 2) {
+		assert.Equal(t, "See you at 5", history.Edits[0].Content)
+		assert.Equal(t, "See you at 6", history.Edits[1].Content)
+	}
+
+	db.Model(&models.Message{}).Where("id = ?", first.ID).Update("created_at", time.Now().Add(-11*time.Minute))
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "PUT", url, alice.ID, gin.H{"content": "Too late"}).Code)
+
+	// Deleting for yourself leaves the message to the other participant
+	assert.Equal(t, http.StatusBadRequest, doJSON(router, "DELETE", url+"?for=nobody", bob.ID, nil).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", url, bob.ID, nil).Code)
+	assert.Len(t, conversation(bob.ID, alice.ID), 0)
+	assert.Len(t, conversation(alice.ID, bob.ID), 1)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "DELETE", url, bob.ID, nil).Code)
+
+	// Only the sender unsends, which removes the message and its files
+	w := doMultipart(router, "/messages", alice.ID, map[string]string{"to_user_id": strconv.Itoa(int(bob.ID))}, "attachments",
+		map[string][]byte{"invoice.pdf": []byte("%PDF-1.4\n%%EOF\n")})
+	var sent struct {
+		Message models.Message `json:"message"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &sent)
+	if !assert.Len(t, sent.Message.Attachments, 1) {
+		return
+	}
+	var stored models.MessageAttachment
+	db.First(&stored, sent.Message.Attachments[0].ID)
+	attachmentURL := fmt.Sprintf("/messages/attachments/%d", stored.ID)
+	assert.Equal(t, http.StatusOK, doJSON(router, "GET", attachmentURL, bob.ID, nil).Code)
+
+	url = fmt.Sprintf("/messages/%d", sent.Message.ID)
+	assert.Equal(t, http.StatusForbidden, doJSON(router, "DELETE", url+"?for=everyone", bob.ID, nil).Code)
+	assert.Equal(t, http.StatusOK, doJSON(router, "DELETE", url+"?for=everyone", alice.ID, nil).Code)
+	assert.Len(t, conversation(bob.ID, alice.ID), 0)
+	assert.Len(t, conversation(alice.ID, bob.ID), 1)
+	assert.Equal(t, http.StatusNotFound, doJSON(router, "GET", attachmentURL, alice.ID, nil).Code)
+	_, _, err := storage.Get(stored.StorageKey)
+	assert.Equal(t, services.ErrObjectNotFound, err)
+
+	// The message routes fit in the API's route tree
+	assert.NotPanics(t, func() {
+		routes.SetupRoutes(gin.New(), db, nil, nil, nil, nil, nil, nil, nil, nil, messageHandler, nil, nil, func(c *gin.Context) {})
+	})
+}
+
+func TestS3StorageAgainstStandIn(t *testing.T) {
+	// A minimal in-memory stand-in for an S3 compatible service
+	var mutex sync.Mutex
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(data)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	storage := services.NewS3Storage(services.S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "uploads",
+		AccessKeyID:     "test-key",
+		SecretAccessKey: "test-secret",
+	})
+
+	assert.NoError(t, storage.Put("products/1/a.txt", []byte("hello"), "text/plain"))
+	assert.Contains(t, objects, "/uploads/products/1/a.txt")
+	assert.Equal(t, server.URL+"/uploads/products/1/a.txt", storage.URL("products/1/a.txt"))
+
+	reader, contentType, err := storage.Get("products/1/a.txt")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "hello", string(data))
+	assert.Equal(t, "text/plain", contentType)
+
+	assert.NoError(t, storage.Delete("products/1/a.txt"))
+	_, _, err = storage.Get("products/1/a.txt")
+	assert.Equal(t, services.ErrObjectNotFound, err)
+}
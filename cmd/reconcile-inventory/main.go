@@ -0,0 +1,53 @@
+// Command reconcile-inventory compares the stock of every product and variant
+// with the inventory ledger and reports any drift. Run with -fix to record
+// adjustments that bring the ledger back in line with the stock.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"ecommerce-app/config"
+	"ecommerce-app/services"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "record adjustments for the drift found")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using system environment variables")
+	}
+
+	db, err := config.InitDB()
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+
+	drifts, err := services.ReconcileInventory(db, *fix)
+	if err != nil {
+		log.Fatal("Failed to reconcile inventory:", err)
+	}
+
+	for _, drift := range drifts {
+		target := fmt.Sprintf("product %d", drift.ProductID)
+		if drift.VariantID != nil {
+			target += fmt.Sprintf(" variant %d", *drift.VariantID)
+		}
+		fmt.Printf("%s: stock %d, ledger %d, drift %+d\n", target, drift.Stock, drift.LedgerStock, drift.Difference())
+	}
+
+	switch {
+	case len(drifts) == 0:
+		fmt.Println("Inventory is in sync with the ledger")
+	case *fix:
+		fmt.Printf("Recorded %d reconciliation adjustments\n", len(drifts))
+	default:
+		fmt.Printf("Found %d items with drift, rerun with -fix to record adjustments\n", len(drifts))
+		os.Exit(1)
+	}
+}
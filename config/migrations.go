@@ -0,0 +1,182 @@
+package config
+
+import (
+	"strings"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"gorm.io/gorm"
+)
+
+// MigrateProductCategories maps the legacy free-text products.category column
+// onto rows of the categories table. Names that slugify identically, such as
+// "Shoes" and "shoes", end up in the same category. Products that already
+// have a category_id are left untouched, so the migration is safe to rerun.
+func MigrateProductCategories(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Product{}, "category") {
+		return nil
+	}
+
+	var names []string
+	if err := db.Model(&models.Product{}).
+		Where("category_id IS NULL AND category IS NOT NULL AND category <> ''").
+		Distinct().Pluck("category", &names).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range names {
+			slug := models.Slugify(name)
+			if slug == "" {
+				continue
+			}
+
+			category := models.Category{Name: strings.TrimSpace(name), Slug: slug}
+			if err := tx.Where("slug = ?", slug).FirstOrCreate(&category).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&models.Product{}).
+				Where("category = ? AND category_id IS NULL", name).
+				Update("category_id", category.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateCategorySlugs drops the unique index that covered the slugs of
+// deleted categories as well, which kept them from being reused. The model's
+// index only covers categories that are not deleted.
+func MigrateCategorySlugs(db *gorm.DB) error {
+	if !db.Migrator().HasIndex(&models.Category{}, "idx_categories_slug") {
+		return nil
+	}
+	return db.Migrator().DropIndex(&models.Category{}, "idx_categories_slug")
+}
+
+// MigrateInventoryLedger records an opening balance for every product and
+// variant that has stock but no ledger entries yet, which is the case for
+// stock that existed before the ledger was introduced. Rerunning it only
+// touches rows that still have no entries.
+func MigrateInventoryLedger(db *gorm.DB) error {
+	var products []models.Product
+	if err := db.Where("stock <> 0 AND NOT EXISTS (?)",
+		db.Model(&models.InventoryMovement{}).Select("1").
+			Where("inventory_movements.product_id = products.id AND inventory_movements.variant_id IS NULL"),
+	).Find(&products).Error; err != nil {
+		return err
+	}
+
+	var variants []models.ProductVariant
+	if err := db.Where("stock <> 0 AND NOT EXISTS (?)",
+		db.Model(&models.InventoryMovement{}).Select("1").
+			Where("inventory_movements.variant_id = product_variants.id"),
+	).Find(&variants).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, product := range products {
+			if err := tx.Create(&models.InventoryMovement{
+				ProductID:  product.ID,
+				Type:       models.InventoryMovementAdjustment,
+				Quantity:   product.Stock,
+				StockAfter: product.Stock,
+				Reason:     "Opening balance",
+			}).Error; err != nil {
+				return err
+			}
+		}
+		for _, variant := range variants {
+			variantID := variant.ID
+			if err := tx.Create(&models.InventoryMovement{
+				ProductID:  variant.ProductID,
+				VariantID:  &variantID,
+				Type:       models.InventoryMovementAdjustment,
+				Quantity:   variant.Stock,
+				StockAfter: variant.Stock,
+				Reason:     "Opening balance",
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateVariantSKUs replaces the table-wide unique index on variant SKUs,
+// which made one seller's SKUs unavailable to every other seller, with the
+// per-product index of the model, and frees the SKUs of deleted variants.
+func MigrateVariantSKUs(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&models.ProductVariant{}, "idx_product_variants_sku") {
+		if err := db.Migrator().DropIndex(&models.ProductVariant{}, "idx_product_variants_sku"); err != nil {
+			return err
+		}
+	}
+	return db.Unscoped().Model(&models.ProductVariant{}).
+		Where("deleted_at IS NOT NULL AND sku IS NOT NULL").
+		Update("sku", nil).Error
+}
+
+// MigrateVerifiedPurchases flags the reviews whose authors have a delivered
+// order of the reviewed product, which covers reviews written before
+// verified purchases were tracked.
+func MigrateVerifiedPurchases(db *gorm.DB) error {
+	return db.Model(&models.Review{}).
+		Where("verified_purchase = ? AND EXISTS (?)", false,
+			db.Model(&models.OrderItem{}).Select("1").
+				Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+				Where("orders.user_id = reviews.user_id AND order_items.product_id = reviews.product_id AND orders.status = ?", models.OrderStatusDelivered),
+		).
+		Update("verified_purchase", true).Error
+}
+
+// MigrateConversations moves messages written before conversations existed
+// into one general conversation per pair of users. Rerunning it only
+// touches messages that still have no conversation.
+func MigrateConversations(db *gorm.DB) error {
+	var pairs []struct {
+		LowUserID  uint
+		HighUserID uint
+	}
+	if err := db.Unscoped().Model(&models.Message{}).
+		Select("CASE WHEN from_user_id < to_user_id THEN from_user_id ELSE to_user_id END AS low_user_id, " +
+			"CASE WHEN from_user_id < to_user_id THEN to_user_id ELSE from_user_id END AS high_user_id").
+		Where("conversation_id = 0").
+		Group("low_user_id, high_user_id").
+		Scan(&pairs).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, pair := range pairs {
+			conversation, err := services.FindOrCreateConversation(tx, pair.LowUserID, pair.HighUserID, nil, nil)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Unscoped().Model(&models.Message{}).
+				Where("(from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)",
+					pair.LowUserID, pair.HighUserID, pair.HighUserID, pair.LowUserID).
+				Where("conversation_id = 0").
+				Update("conversation_id", conversation.ID).Error; err != nil {
+				return err
+			}
+
+			var latest models.Message
+			if err := tx.Where("conversation_id = ?", conversation.ID).Order("created_at DESC").First(&latest).Error; err != nil {
+				if err == gorm.ErrRecordNotFound {
+					continue
+				}
+				return err
+			}
+			if err := tx.Model(conversation).Update("last_message_at", latest.CreatedAt).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
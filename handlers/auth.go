@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -31,11 +32,17 @@ type RegisterRequest struct {
 	Password  string `json:"password" binding:"required,min=6"`
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
+	CartToken string `json:"cart_token"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required"`
+	CartToken string `json:"cart_token"`
+}
+
+type UpdatePreferencesRequest struct {
+	CartReminders *bool `json:"cart_reminders"`
 }
 
 type ResetPasswordRequest struct {
@@ -47,6 +54,28 @@ type NewPasswordRequest struct {
 	Password string `json:"password" binding:"required,min=6"`
 }
 
+// mergeCart moves the guest cart named by the request's cart token, sent in
+// the body or the X-Cart-Token header, into the user's cart. It returns nil
+// when there was no guest cart to merge.
+func (h *AuthHandler) mergeCart(c *gin.Context, userID uint, cartToken string) gin.H {
+	if cartToken == "" {
+		cartToken = c.GetHeader(cartTokenHeader)
+	}
+	if cartToken == "" {
+		return nil
+	}
+
+	adjustments, err := mergeGuestCart(h.db, userID, cartToken)
+	if err != nil {
+		// An expired token or an already merged cart is not worth failing for
+		if err != errInvalidCartToken && err != gorm.ErrRecordNotFound {
+			log.Printf("Failed to merge guest cart for user %d: %v", userID, err)
+		}
+		return nil
+	}
+	return gin.H{"merged": true, "adjustments": adjustments}
+}
+
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -91,22 +120,28 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	// Create cart for user
-	cart := models.Cart{UserID: user.ID}
+	cart := models.Cart{UserID: &user.ID}
 	if err := h.db.Create(&cart).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
 		return
 	}
 
+	cartMerge := h.mergeCart(c, user.ID, req.CartToken)
+
 	// Send confirmation email
 	if err := h.emailService.SendEmailConfirmation(user.Email, token); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send confirmation email"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
+	response := gin.H{
 		"message": "User registered successfully. Please check your email to confirm your account.",
 		"user_id": user.ID,
-	})
+	}
+	if cartMerge != nil {
+		response["cart_merge"] = cartMerge
+	}
+	c.JSON(http.StatusCreated, response)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -148,7 +183,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"token": tokenString,
 		"user": gin.H{
 			"id":         user.ID,
@@ -156,7 +191,11 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			"first_name": user.FirstName,
 			"last_name":  user.LastName,
 		},
-	})
+	}
+	if cartMerge := h.mergeCart(c, user.ID, req.CartToken); cartMerge != nil {
+		response["cart_merge"] = cartMerge
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *AuthHandler) ConfirmEmail(c *gin.Context) {
@@ -183,6 +222,28 @@ func (h *AuthHandler) ConfirmEmail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Email confirmed successfully"})
 }
 
+// UpdatePreferences changes the email preferences of the user, currently
+// whether abandoned cart reminders are sent.
+func (h *AuthHandler) UpdatePreferences(c *gin.Context) {
+	user := c.MustGet("user").(models.User)
+
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.CartReminders != nil {
+		user.CartRemindersOptOut = !*req.CartReminders
+		if err := h.db.Model(&user).Update("cart_reminders_opt_out", user.CartRemindersOptOut).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": gin.H{"cart_reminders": !user.CartRemindersOptOut}})
+}
+
 func (h *AuthHandler) RequestPasswordReset(c *gin.Context) {
 	var req ResetPasswordRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
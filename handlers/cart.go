@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
 	"ecommerce-app/models"
+	"ecommerce-app/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -19,33 +23,94 @@ func NewCartHandler(db *gorm.DB) *CartHandler {
 }
 
 type AddToCartRequest struct {
-	ProductID uint `json:"product_id" binding:"required"`
-	Quantity  int  `json:"quantity" binding:"required,gt=0"`
+	ProductID uint  `json:"product_id" binding:"required"`
+	VariantID *uint `json:"variant_id"`
+	Quantity  int   `json:"quantity" binding:"required,gt=0"`
 }
 
 type UpdateCartItemRequest struct {
 	Quantity int `json:"quantity" binding:"required,gt=0"`
 }
 
-func (h *CartHandler) GetCart(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
+// addCartItem adds quantity of a product, or of one of its variants, to a
+// cart, merging it with an existing item for the same product and variant.
+// It returns services.ErrInsufficientStock when the total would exceed the
+// stock that is not held for other shoppers' checkouts.
+func addCartItem(db *gorm.DB, cartID uint, product models.Product, variant *models.ProductVariant, quantity int) error {
+	var variantID *uint
+	unitPrice := product.Price
+	if variant != nil {
+		variantID = &variant.ID
+		unitPrice = variant.EffectivePrice(product)
+	}
 
-	var cart models.Cart
-	if err := h.db.Preload("CartItems.Product").Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
-			return
+	stock, err := services.AvailableStock(db, product.ID, variantID, cartID)
+	if err != nil {
+		return err
+	}
+
+	// Check if item already exists in cart
+	var item models.CartItem
+	itemQuery := db.Where("cart_id = ? AND product_id = ?", cartID, product.ID)
+	if variantID != nil {
+		itemQuery = itemQuery.Where("variant_id = ?", *variantID)
+	} else {
+		itemQuery = itemQuery.Where("variant_id IS NULL")
+	}
+	if err := itemQuery.First(&item).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if quantity > stock {
+			return services.ErrInsufficientStock
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+
+		// Create new cart item
+		return db.Create(&models.CartItem{
+			CartID:     cartID,
+			ProductID:  product.ID,
+			VariantID:  variantID,
+			Quantity:   quantity,
+			PriceAtAdd: unitPrice,
+		}).Error
+	}
+
+	// Update quantity, keeping a checkout reservation in step
+	newQuantity := item.Quantity + quantity
+	if newQuantity > stock {
+		return services.ErrInsufficientStock
+	}
+	item.Quantity = newQuantity
+	item.PriceAtAdd = unitPrice
+	if err := db.Save(&item).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.StockReservation{}).Where("cart_item_id = ?", item.ID).Update("quantity", newQuantity).Error
+}
+
+func (h *CartHandler) GetCart(c *gin.Context) {
+	cart, err := h.findCart(c, preloadCartItems(h.db).Preload("CartItems.Variant.OptionValues"))
+	if err != nil {
+		cartLookupError(c, err)
+		return
+	}
+
+	warnings, err := cartWarnings(h.db, *cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cart items"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"cart": cart})
+	response := gin.H{"cart": cart, "warnings": warnings}
+	var reservation models.StockReservation
+	if err := h.db.Where("cart_id = ? AND expires_at > ?", cart.ID, time.Now()).Order("expires_at ASC").First(&reservation).Error; err == nil {
+		response["reserved_until"] = reservation.ExpiresAt
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *CartHandler) AddToCart(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
-
 	var req AddToCartRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -54,7 +119,7 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 
 	// Check if product exists and has enough stock
 	var product models.Product
-	if err := h.db.Where("id = ? AND is_active = ?", req.ProductID, true).First(&product).Error; err != nil {
+	if err := h.db.Where("id = ? AND is_active = ? AND is_hidden = ?", req.ProductID, true, false).First(&product).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -63,58 +128,44 @@ func (h *CartHandler) AddToCart(c *gin.Context) {
 		return
 	}
 
-	if product.Stock < req.Quantity {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+	variant, err := loadVariant(h.db, product.ID, req.VariantID)
+	if err != nil {
+		if err == errVariantRequired {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variant is required for this product"})
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variant"})
 		return
 	}
 
 	// Get or create cart
-	var cart models.Cart
-	if err := h.db.Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			cart = models.Cart{UserID: userID}
-			if err := h.db.Create(&cart).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create cart"})
-				return
-			}
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
-			return
-		}
+	cart, err := h.findOrCreateCart(c)
+	if err != nil {
+		cartLookupError(c, err)
+		return
 	}
 
-	// Check if item already exists in cart
-	var existingItem models.CartItem
-	if err := h.db.Where("cart_id = ? AND product_id = ?", cart.ID, req.ProductID).First(&existingItem).Error; err == nil {
-		// Update quantity
-		newQuantity := existingItem.Quantity + req.Quantity
-		if newQuantity > product.Stock {
+	if err := addCartItem(h.db, cart.ID, product, variant, req.Quantity); err != nil {
+		if err == services.ErrInsufficientStock {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
 			return
 		}
-		existingItem.Quantity = newQuantity
-		if err := h.db.Save(&existingItem).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
-			return
-		}
-	} else {
-		// Create new cart item
-		cartItem := models.CartItem{
-			CartID:    cart.ID,
-			ProductID: req.ProductID,
-			Quantity:  req.Quantity,
-		}
-		if err := h.db.Create(&cartItem).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
-			return
-		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to cart"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Item added to cart successfully"})
+	response := gin.H{"message": "Item added to cart successfully"}
+	if token, ok := c.Get("cart_token"); ok {
+		response["cart_token"] = token
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *CartHandler) UpdateCartItem(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
 	itemID := c.Param("id")
 	cartItemID, err := strconv.ParseUint(itemID, 10, 32)
 	if err != nil {
@@ -128,10 +179,16 @@ func (h *CartHandler) UpdateCartItem(c *gin.Context) {
 		return
 	}
 
+	cart, err := h.findCart(c, h.db)
+	if err != nil {
+		cartLookupError(c, err)
+		return
+	}
+
 	// Get cart item with product info
 	var cartItem models.CartItem
-	if err := h.db.Preload("Product").Joins("JOIN carts ON carts.id = cart_items.cart_id").
-		Where("cart_items.id = ? AND carts.user_id = ?", cartItemID, userID).First(&cartItem).Error; err != nil {
+	if err := h.db.Preload("Product").Preload("Variant").
+		Where("id = ? AND cart_id = ?", cartItemID, cart.ID).First(&cartItem).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
 			return
@@ -141,23 +198,28 @@ func (h *CartHandler) UpdateCartItem(c *gin.Context) {
 	}
 
 	// Check stock availability
-	if req.Quantity > cartItem.Product.Stock {
+	available, err := services.AvailableStock(h.db, cartItem.ProductID, cartItem.VariantID, cartItem.CartID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return
+	}
+	if req.Quantity > available {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
 		return
 	}
 
-	// Update quantity
+	// Update quantity, keeping a checkout reservation in step
 	cartItem.Quantity = req.Quantity
 	if err := h.db.Save(&cartItem).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
 		return
 	}
+	h.db.Model(&models.StockReservation{}).Where("cart_item_id = ?", cartItem.ID).Update("quantity", req.Quantity)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Cart item updated successfully"})
 }
 
 func (h *CartHandler) RemoveFromCart(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
 	itemID := c.Param("id")
 	cartItemID, err := strconv.ParseUint(itemID, 10, 32)
 	if err != nil {
@@ -165,11 +227,15 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 		return
 	}
 
-	// Verify the cart item belongs to the user's cart
+	cart, err := h.findCart(c, h.db)
+	if err != nil {
+		cartLookupError(c, err)
+		return
+	}
+
+	// Verify the cart item belongs to the caller's cart
 	var cartItem models.CartItem
-	if err := h.db.Joins("JOIN carts ON carts.id = cart_items.cart_id").
-		Where("cart_items.id = ? AND carts.user_id = ?", cartItemID, userID).
-		First(&cartItem).Error; err != nil {
+	if err := h.db.Where("id = ? AND cart_id = ?", cartItemID, cart.ID).First(&cartItem).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
 			return
@@ -178,8 +244,13 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 		return
 	}
 
-	// Now delete by primary key to avoid JOINs in DELETE
-	if err := h.db.Delete(&models.CartItem{}, cartItem.ID).Error; err != nil {
+	// Delete by primary key, releasing any stock reserved for the item
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_item_id = ?", cartItem.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.CartItem{}, cartItem.ID).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from cart"})
 		return
 	}
@@ -188,24 +259,109 @@ func (h *CartHandler) RemoveFromCart(c *gin.Context) {
 }
 
 func (h *CartHandler) ClearCart(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
-
 	// Get cart
-	var cart models.Cart
-	if err := h.db.Where("user_id = ?", userID).First(&cart).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+	cart, err := h.findCart(c, h.db)
+	if err != nil {
+		cartLookupError(c, err)
 		return
 	}
 
-	// Delete all cart items
-	if err := h.db.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+	// Delete all cart items and release their reservations
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Cart cleared successfully"})
 }
+
+// errItemsUnavailable rolls back a checkout when an item cannot be reserved.
+var errItemsUnavailable = errors.New("items unavailable")
+
+// StartCheckout reserves the stock of every cart item for
+// services.ReservationTTL, so the items cannot sell out while the shopper
+// completes the order. Calling it again renews the reservations.
+func (h *CartHandler) StartCheckout(c *gin.Context) {
+	cart, err := h.findCart(c, h.db.Preload("CartItems.Product"))
+	if err != nil {
+		cartLookupError(c, err)
+		return
+	}
+
+	if len(cart.CartItems) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+		return
+	}
+
+	expiresAt := time.Now().Add(services.ReservationTTL())
+	var reservations []models.StockReservation
+	var unavailable []gin.H
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Lock in a fixed order so concurrent checkouts cannot deadlock
+		locked := append([]models.CartItem(nil), cart.CartItems...)
+		sort.Slice(locked, func(i, j int) bool {
+			if locked[i].ProductID != locked[j].ProductID {
+				return locked[i].ProductID < locked[j].ProductID
+			}
+			return locked[i].VariantID != nil && (locked[j].VariantID == nil || *locked[i].VariantID < *locked[j].VariantID)
+		})
+		for _, item := range locked {
+			if err := services.LockStock(tx, item.ProductID, item.VariantID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+
+		for _, item := range cart.CartItems {
+			available, err := services.AvailableStock(tx, item.ProductID, item.VariantID, cart.ID)
+			if err != nil {
+				return err
+			}
+			if available < item.Quantity {
+				unavailable = append(unavailable, gin.H{
+					"cart_item_id": item.ID,
+					"product_id":   item.ProductID,
+					"variant_id":   item.VariantID,
+					"name":         item.Product.Name,
+					"requested":    item.Quantity,
+					"available":    max(available, 0),
+				})
+				continue
+			}
+			reservations = append(reservations, models.StockReservation{
+				CartID:     cart.ID,
+				CartItemID: item.ID,
+				ProductID:  item.ProductID,
+				VariantID:  item.VariantID,
+				Quantity:   item.Quantity,
+				ExpiresAt:  expiresAt,
+			})
+		}
+
+		// Reserve all items or none of them, keeping the earlier holds
+		if len(unavailable) > 0 {
+			return errItemsUnavailable
+		}
+		return tx.Create(&reservations).Error
+	}); err != nil {
+		if err == errItemsUnavailable {
+			c.JSON(http.StatusConflict, gin.H{"error": "Some items are no longer available in the requested quantity", "items": unavailable})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reserve stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reservations":   reservations,
+		"reserved_until": expiresAt,
+	})
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"gorm.io/gorm"
+)
+
+// Codes of the warnings GetCart and CreateOrder report for cart items.
+const (
+	cartWarningPriceChanged = "price_changed"
+	cartWarningOutOfStock   = "out_of_stock"
+	cartWarningInactive     = "inactive"
+	cartWarningDeleted      = "deleted"
+)
+
+// cartItemWarning tells the buyer that a cart item changed since it was
+// added. Only price changes can be acknowledged; the other warnings block
+// checkout until the item is updated or removed.
+type cartItemWarning struct {
+	CartItemID    uint     `json:"cart_item_id"`
+	ProductID     uint     `json:"product_id"`
+	VariantID     *uint    `json:"variant_id,omitempty"`
+	Code          string   `json:"code"`
+	Message       string   `json:"message"`
+	PreviousPrice *float64 `json:"previous_price,omitempty"`
+	CurrentPrice  *float64 `json:"current_price,omitempty"`
+	Available     *int     `json:"available,omitempty"`
+}
+
+func (w cartItemWarning) blocking() bool {
+	return w.Code != cartWarningPriceChanged
+}
+
+// preloadCartItems preloads the items of a cart including deleted products
+// and variants, so that their removal can be reported.
+func preloadCartItems(db *gorm.DB) *gorm.DB {
+	unscoped := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	return db.Preload("CartItems.Product", unscoped).Preload("CartItems.Variant", unscoped)
+}
+
+// cartWarnings compares every item of cart, loaded with preloadCartItems,
+// with the current state of its product.
+func cartWarnings(db *gorm.DB, cart models.Cart) ([]cartItemWarning, error) {
+	warnings := []cartItemWarning{}
+	for _, item := range cart.CartItems {
+		warning := cartItemWarning{CartItemID: item.ID, ProductID: item.ProductID, VariantID: item.VariantID}
+
+		switch {
+		case item.Product.ID == 0 || item.Product.DeletedAt.Valid || (item.Variant != nil && item.Variant.DeletedAt.Valid):
+			warning.Code, warning.Message = cartWarningDeleted, "This product is no longer available"
+			warnings = append(warnings, warning)
+			continue
+		case !item.Product.IsAvailable() || (item.Variant != nil && !item.Variant.IsActive):
+			warning.Code, warning.Message = cartWarningInactive, "This product is currently not for sale"
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		available, err := services.AvailableStock(db, item.ProductID, item.VariantID, cart.ID)
+		if err != nil {
+			return nil, err
+		}
+		if available < item.Quantity {
+			stockWarning := warning
+			stockWarning.Code, stockWarning.Message = cartWarningOutOfStock, "Only part of the requested quantity is in stock"
+			if available <= 0 {
+				available = 0
+				stockWarning.Message = "This product is out of stock"
+			}
+			stockWarning.Available = &available
+			warnings = append(warnings, stockWarning)
+		}
+
+		if price := item.UnitPrice(); item.PriceAtAdd != 0 && price != item.PriceAtAdd {
+			previous := item.PriceAtAdd
+			priceWarning := warning
+			priceWarning.Code, priceWarning.Message = cartWarningPriceChanged, "The price changed since this item was added"
+			priceWarning.PreviousPrice = &previous
+			priceWarning.CurrentPrice = &price
+			warnings = append(warnings, priceWarning)
+		}
+	}
+	return warnings, nil
+}
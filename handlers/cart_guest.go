@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// cartTokenHeader carries the signed token that identifies a guest cart.
+const cartTokenHeader = "X-Cart-Token"
+
+// guestCartTTL is how long a guest cart token stays valid.
+const guestCartTTL = 30 * 24 * time.Hour
+
+var (
+	errNoCartToken      = errors.New("no cart token")
+	errInvalidCartToken = errors.New("invalid cart token")
+)
+
+// newCartToken signs the guest ID of a cart so visitors cannot guess the
+// carts of others.
+func newCartToken(guestID string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"guest_cart": guestID,
+		"exp":        time.Now().Add(guestCartTTL).Unix(),
+	})
+	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+}
+
+// parseCartToken returns the guest ID from a cart token. Login tokens are
+// rejected because they carry no guest_cart claim.
+func parseCartToken(tokenString string) (string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return "", errInvalidCartToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", errInvalidCartToken
+	}
+	guestID, ok := claims["guest_cart"].(string)
+	if !ok || guestID == "" {
+		return "", errInvalidCartToken
+	}
+	return guestID, nil
+}
+
+// cartScope restricts a query on carts to the cart of the caller: the cart
+// of the logged in user or else the guest cart named by the cart token.
+func cartScope(c *gin.Context) (func(*gorm.DB) *gorm.DB, error) {
+	if userID, ok := c.Get("user_id"); ok {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("carts.user_id = ?", userID.(uint))
+		}, nil
+	}
+
+	tokenString := c.GetHeader(cartTokenHeader)
+	if tokenString == "" {
+		return nil, errNoCartToken
+	}
+	guestID, err := parseCartToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("carts.guest_id = ?", guestID)
+	}, nil
+}
+
+// findCart loads the caller's cart, returning gorm.ErrRecordNotFound when a
+// guest has no cart token yet.
+func (h *CartHandler) findCart(c *gin.Context, query *gorm.DB) (*models.Cart, error) {
+	scope, err := cartScope(c)
+	if err == errNoCartToken {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cart models.Cart
+	if err := query.Scopes(scope).First(&cart).Error; err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// findOrCreateCart loads the caller's cart, creating it when needed. New
+// guest carts get a cart token, returned in the X-Cart-Token header.
+func (h *CartHandler) findOrCreateCart(c *gin.Context) (*models.Cart, error) {
+	cart, err := h.findCart(c, h.db)
+	if err != gorm.ErrRecordNotFound {
+		return cart, err
+	}
+
+	if userID, ok := c.Get("user_id"); ok {
+		id := userID.(uint)
+		cart = &models.Cart{UserID: &id}
+		return cart, h.db.Create(cart).Error
+	}
+
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	guestID := hex.EncodeToString(bytes)
+	token, err := newCartToken(guestID)
+	if err != nil {
+		return nil, err
+	}
+
+	cart = &models.Cart{GuestID: &guestID}
+	if err := h.db.Create(cart).Error; err != nil {
+		return nil, err
+	}
+	c.Header(cartTokenHeader, token)
+	c.Set("cart_token", token)
+	return cart, nil
+}
+
+// cartLookupError writes the response for a failed cart lookup.
+func cartLookupError(c *gin.Context, err error) {
+	switch err {
+	case gorm.ErrRecordNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+	case errInvalidCartToken:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid cart token"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart"})
+	}
+}
+
+// cartMergeAdjustment reports a guest cart item that could not be merged in
+// full because of the available stock.
+type cartMergeAdjustment struct {
+	ProductID uint  `json:"product_id"`
+	VariantID *uint `json:"variant_id"`
+	Requested int   `json:"requested"`
+	Quantity  int   `json:"quantity"`
+}
+
+// mergeGuestCart moves the items of the guest cart named by tokenString into
+// the cart of the user and deletes the guest cart. Quantities of items in
+// both carts are added up; items are capped at the stock available to the
+// user and dropped when nothing is left or the product is no longer sold.
+func mergeGuestCart(db *gorm.DB, userID uint, tokenString string) ([]cartMergeAdjustment, error) {
+	guestID, err := parseCartToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	var guestCart models.Cart
+	if err := db.Preload("CartItems.Product").Preload("CartItems.Variant").
+		Where("guest_id = ?", guestID).First(&guestCart).Error; err != nil {
+		return nil, err
+	}
+
+	adjustments := []cartMergeAdjustment{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart
+		if err := tx.Where("user_id = ?", userID).First(&cart).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			cart = models.Cart{UserID: &userID}
+			if err := tx.Create(&cart).Error; err != nil {
+				return err
+			}
+		}
+
+		// The guest's checkout reservations do not carry over
+		if err := tx.Where("cart_id = ?", guestCart.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+
+		for _, guestItem := range guestCart.CartItems {
+			var item models.CartItem
+			itemQuery := tx.Where("cart_id = ? AND product_id = ?", cart.ID, guestItem.ProductID)
+			if guestItem.VariantID != nil {
+				itemQuery = itemQuery.Where("variant_id = ?", *guestItem.VariantID)
+			} else {
+				itemQuery = itemQuery.Where("variant_id IS NULL")
+			}
+			// Merged items keep the price the user saw when adding them,
+			// the user's own snapshot winning over the guest's
+			existing := itemQuery.First(&item).Error == nil
+			if !existing {
+				item = models.CartItem{CartID: cart.ID, ProductID: guestItem.ProductID, VariantID: guestItem.VariantID, PriceAtAdd: guestItem.PriceAtAdd}
+			}
+
+			requested := item.Quantity + guestItem.Quantity
+			available := 0
+			sellable := guestItem.Product.ID != 0 && guestItem.Product.IsAvailable() &&
+				(guestItem.VariantID == nil || (guestItem.Variant != nil && guestItem.Variant.IsActive))
+			if sellable {
+				if available, err = services.AvailableStock(tx, guestItem.ProductID, guestItem.VariantID, cart.ID); err != nil {
+					return err
+				}
+			}
+
+			quantity := min(requested, max(available, 0))
+			if existing {
+				// The user's own items are kept as they were if stock is short
+				quantity = max(quantity, item.Quantity)
+			}
+			if quantity != requested {
+				adjustments = append(adjustments, cartMergeAdjustment{
+					ProductID: guestItem.ProductID,
+					VariantID: guestItem.VariantID,
+					Requested: requested,
+					Quantity:  quantity,
+				})
+			}
+
+			switch {
+			case quantity == 0:
+				continue
+			case existing:
+				updates := map[string]interface{}{"quantity": quantity}
+				if item.PriceAtAdd == 0 {
+					updates["price_at_add"] = guestItem.PriceAtAdd
+				}
+				if err := tx.Model(&item).Updates(updates).Error; err != nil {
+					return err
+				}
+			default:
+				item.Quantity = quantity
+				if err := tx.Create(&item).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Where("cart_id = ?", guestCart.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&guestCart).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adjustments, nil
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CategoryHandler struct {
+	db *gorm.DB
+}
+
+func NewCategoryHandler(db *gorm.DB) *CategoryHandler {
+	return &CategoryHandler{db: db}
+}
+
+type CreateCategoryRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	ParentID    *uint  `json:"parent_id"`
+	Position    int    `json:"position"`
+}
+
+type UpdateCategoryRequest struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	ParentID    *uint  `json:"parent_id"`
+	MakeRoot    bool   `json:"make_root"`
+	Position    *int   `json:"position"`
+}
+
+// buildCategoryTree nests the given flat list of categories under their parents.
+func buildCategoryTree(categories []models.Category, parentID *uint) []models.Category {
+	tree := []models.Category{}
+	for _, category := range categories {
+		if (parentID == nil && category.ParentID == nil) ||
+			(parentID != nil && category.ParentID != nil && *category.ParentID == *parentID) {
+			id := category.ID
+			category.Children = buildCategoryTree(categories, &id)
+			tree = append(tree, category)
+		}
+	}
+	return tree
+}
+
+// categoryDescendantIDs returns the ID of root followed by the IDs of all of
+// its descendants.
+func categoryDescendantIDs(db *gorm.DB, rootID uint) ([]uint, error) {
+	var categories []models.Category
+	if err := db.Select("id, parent_id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]uint)
+	for _, category := range categories {
+		if category.ParentID != nil {
+			children[*category.ParentID] = append(children[*category.ParentID], category.ID)
+		}
+	}
+
+	ids := []uint{rootID}
+	for i := 0; i < len(ids); i++ {
+		ids = append(ids, children[ids[i]]...)
+	}
+	return ids, nil
+}
+
+// findCategory looks a category up by numeric ID or by slug.
+func findCategory(db *gorm.DB, idOrSlug string) (models.Category, error) {
+	var category models.Category
+	if id, err := strconv.ParseUint(idOrSlug, 10, 32); err == nil {
+		err := db.First(&category, id).Error
+		return category, err
+	}
+	err := db.Where("slug = ?", models.Slugify(idOrSlug)).First(&category).Error
+	return category, err
+}
+
+func (h *CategoryHandler) GetCategories(c *gin.Context) {
+	var categories []models.Category
+	if err := h.db.Order("position ASC, name ASC").Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": buildCategoryTree(categories, nil)})
+}
+
+func (h *CategoryHandler) GetCategory(c *gin.Context) {
+	category, err := findCategory(h.db, c.Param("id"))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return
+	}
+
+	var categories []models.Category
+	if err := h.db.Order("position ASC, name ASC").Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	// Breadcrumb from the root down to this category
+	byID := make(map[uint]models.Category)
+	for _, cat := range categories {
+		byID[cat.ID] = cat
+	}
+	var path []gin.H
+	for current, ok := byID[category.ID]; ok; {
+		path = append([]gin.H{{"id": current.ID, "name": current.Name, "slug": current.Slug}}, path...)
+		if current.ParentID == nil {
+			break
+		}
+		current, ok = byID[*current.ParentID]
+	}
+
+	category.Children = buildCategoryTree(categories, &category.ID)
+
+	c.JSON(http.StatusOK, gin.H{"category": category, "path": path})
+}
+
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	var req CreateCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	slug := models.Slugify(req.Slug)
+	if slug == "" {
+		slug = models.Slugify(req.Name)
+	}
+	if slug == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category slug"})
+		return
+	}
+
+	var existing models.Category
+	if err := h.db.Where("slug = ?", slug).First(&existing).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category slug already exists"})
+		return
+	}
+
+	if req.ParentID != nil {
+		var parent models.Category
+		if err := h.db.First(&parent, *req.ParentID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Parent category not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parent category"})
+			return
+		}
+	}
+
+	category := models.Category{
+		Name:        req.Name,
+		Slug:        slug,
+		Description: req.Description,
+		ParentID:    req.ParentID,
+		Position:    req.Position,
+	}
+
+	if err := h.db.Create(&category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"category": category})
+}
+
+func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	var req UpdateCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return
+	}
+
+	if req.Name != "" {
+		category.Name = req.Name
+	}
+	if req.Slug != "" {
+		slug := models.Slugify(req.Slug)
+		var existing models.Category
+		if err := h.db.Where("slug = ? AND id <> ?", slug, category.ID).First(&existing).Error; err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Category slug already exists"})
+			return
+		}
+		category.Slug = slug
+	}
+	if req.Description != "" {
+		category.Description = req.Description
+	}
+	if req.Position != nil {
+		category.Position = *req.Position
+	}
+	if req.MakeRoot {
+		category.ParentID = nil
+	} else if req.ParentID != nil {
+		// Moving a category below one of its own descendants would create a cycle
+		descendants, err := categoryDescendantIDs(h.db, category.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+			return
+		}
+		for _, descendantID := range descendants {
+			if descendantID == *req.ParentID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Category cannot be moved below itself"})
+				return
+			}
+		}
+
+		var parent models.Category
+		if err := h.db.First(&parent, *req.ParentID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Parent category not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parent category"})
+			return
+		}
+		category.ParentID = req.ParentID
+	}
+
+	if err := h.db.Save(&category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+		return
+	}
+
+	var childCount int64
+	h.db.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&childCount)
+	if childCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category has subcategories"})
+		return
+	}
+
+	var productCount int64
+	h.db.Model(&models.Product{}).Where("category_id = ?", category.ID).Count(&productCount)
+	if productCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has products"})
+		return
+	}
+
+	if err := h.db.Delete(&category).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
+}
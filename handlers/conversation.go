@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// conversationSort lists the most recently active conversations first.
+var conversationSort = keysetSort{Expr: "conversations.last_message_at", IDColumn: "conversations.id", Desc: true, Time: true}
+
+// conversationView is a conversation in the inbox of a participant.
+type conversationView struct {
+	models.Conversation
+	LastMessage *models.Message `json:"last_message"`
+	UnreadCount int64           `json:"unread_count"`
+	Muted       bool            `json:"muted"`
+}
+
+// preloadConversation loads the participants and the product or order of
+// conversations.
+func preloadConversation(db *gorm.DB) *gorm.DB {
+	return db.Preload("Participants.User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name")
+	}).Preload("Product", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id, name, image_url, user_id")
+	}).Preload("Order", func(db *gorm.DB) *gorm.DB {
+		return db.Unscoped().Select("id, user_id, status, created_at")
+	})
+}
+
+// participantOf restricts a query on conversations to those of the user.
+func participantOf(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("EXISTS (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.ConversationParticipant{}).Select("1").
+			Where("conversation_participants.conversation_id = conversations.id AND conversation_participants.user_id = ?", userID))
+	}
+}
+
+// inConversation matches the messages of a conversation.
+func inConversation(conversationID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("conversation_id = ?", conversationID)
+	}
+}
+
+// findConversation loads a conversation of the user, writing the error
+// response when there is none.
+func (h *MessageHandler) findConversation(c *gin.Context, userID, conversationID uint) (*models.Conversation, bool) {
+	var conversation models.Conversation
+	if err := h.db.Scopes(preloadConversation, participantOf(userID)).First(&conversation, conversationID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversation"})
+		return nil, false
+	}
+	return &conversation, true
+}
+
+// GetConversations lists the user's conversations, most recently active
+// first, leaving out those with blocked users. Sellers can narrow their
+// inbox with product_id or order_id.
+func (h *MessageHandler) GetConversations(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var productID, orderID uint64
+	if raw := c.Query("product_id"); raw != "" {
+		if productID, err = strconv.ParseUint(raw, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+	}
+	if raw := c.Query("order_id"); raw != "" {
+		if orderID, err = strconv.ParseUint(raw, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+			return
+		}
+	}
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Scopes(participantOf(userID), withoutBlocked(userID))
+		if productID != 0 {
+			db = db.Where("conversations.product_id = ?", productID)
+		}
+		if orderID != 0 {
+			db = db.Where("conversations.order_id = ?", orderID)
+		}
+		return db
+	}
+
+	var total int64
+	if err := h.db.Model(&models.Conversation{}).Scopes(filter).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count conversations"})
+		return
+	}
+
+	query, err := paginate(h.db.Scopes(preloadConversation, filter), conversationSort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var conversations []models.Conversation
+	if err := query.Find(&conversations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	views := make([]conversationView, 0, len(conversations))
+	for _, conversation := range conversations {
+		view := conversationView{Conversation: conversation, Muted: isMuted(&conversation, userID)}
+		visible := h.db.Model(&models.Message{}).Scopes(inConversation(conversation.ID), visibleMessages(userID))
+
+		var last models.Message
+		if err := visible.Session(&gorm.Session{}).Preload("Attachments").Order("id DESC").First(&last).Error; err == nil {
+			view.LastMessage = &last
+		} else if err != gorm.ErrRecordNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+		if err := visible.Session(&gorm.Session{}).Where("to_user_id = ? AND is_read = ?", userID, false).
+			Count(&view.UnreadCount).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+			return
+		}
+		views = append(views, view)
+	}
+
+	var nextCursor string
+	if len(conversations) == pageReq.Limit {
+		if nextCursor, err = conversationSort.NextCursor(h.db, &models.Conversation{}, conversations[len(conversations)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": views,
+		"pagination":    paginationResponse(pageReq, total, nextCursor),
+	})
+}
+
+// GetConversationMessages returns a conversation of the user with a page of
+// its messages, paged like GetConversation.
+func (h *MessageHandler) GetConversationMessages(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	page, err := parseMessagePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.findConversation(c, userID, uint(conversationID))
+	if !ok {
+		return
+	}
+
+	messages, pagination, err := h.fetchMessagePage(userID, inConversation(conversation.ID), page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversation": conversation,
+		"muted":        isMuted(conversation, userID),
+		"messages":     messages,
+		"pagination":   pagination,
+	})
+}
+
+// MarkConversationMessagesRead marks the messages the user received in a
+// conversation up to and including up_to_message_id as read.
+func (h *MessageHandler) MarkConversationMessagesRead(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req MarkConversationReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, ok := h.findConversation(c, userID, uint(conversationID))
+	if !ok {
+		return
+	}
+
+	marked, err := h.markMessagesRead(userID, inConversation(conversation.ID), req.UpToMessageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
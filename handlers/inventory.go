@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdjustStockRequest struct {
+	VariantID *uint                        `json:"variant_id"`
+	Type      models.InventoryMovementType `json:"type" binding:"required,oneof=restock adjustment return"`
+	Quantity  int                          `json:"quantity" binding:"required,ne=0"`
+	Reason    string                       `json:"reason"`
+}
+
+// findSellerProduct loads a product of the seller from the :id parameter,
+// writing the error response itself when it cannot.
+func (h *ProductHandler) findSellerProduct(c *gin.Context, userID uint) (*models.Product, bool) {
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return nil, false
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND user_id = ?", productID, userID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return nil, false
+	}
+	return &product, true
+}
+
+func (h *ProductHandler) GetInventoryMovements(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	product, ok := h.findSellerProduct(c, userID)
+	if !ok {
+		return
+	}
+
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	filtered := h.db.Model(&models.InventoryMovement{}).Where("product_id = ?", product.ID)
+	if variantID := c.Query("variant_id"); variantID != "" {
+		filtered = filtered.Where("variant_id = ?", variantID)
+	}
+	if movementType := c.Query("type"); movementType != "" {
+		filtered = filtered.Where("type = ?", movementType)
+	}
+
+	var total int64
+	if err := filtered.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count inventory movements"})
+		return
+	}
+
+	sort := createdAtSort("inventory_movements", true)
+	query, err := paginate(filtered.Session(&gorm.Session{}).Preload("Variant").Preload("Actor"), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var movements []models.InventoryMovement
+	if err := query.Find(&movements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch inventory movements"})
+		return
+	}
+
+	var nextCursor string
+	if len(movements) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.InventoryMovement{}, movements[len(movements)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stock":      product.Stock,
+		"movements":  movements,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
+}
+
+func (h *ProductHandler) AdjustStock(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	product, ok := h.findSellerProduct(c, userID)
+	if !ok {
+		return
+	}
+
+	var req AdjustStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only manual adjustments can remove stock
+	if req.Type != models.InventoryMovementAdjustment && req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be positive for restocks and returns"})
+		return
+	}
+
+	if req.VariantID != nil {
+		var variant models.ProductVariant
+		if err := h.db.Where("id = ? AND product_id = ?", *req.VariantID, product.ID).First(&variant).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+	}
+
+	var movement *models.InventoryMovement
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = services.RecordStockMovement(tx, services.StockMovement{
+			ProductID: product.ID,
+			VariantID: req.VariantID,
+			Type:      req.Type,
+			Quantity:  req.Quantity,
+			Reason:    req.Reason,
+			ActorID:   &userID,
+		})
+		return err
+	}); err != nil {
+		if err == services.ErrInsufficientStock {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Stock cannot go below zero"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+		return
+	}
+
+	h.alerts.StockChanged(product.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"movement": movement})
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
@@ -15,32 +19,172 @@ import (
 type MessageHandler struct {
 	db               *gorm.DB
 	websocketService *services.WebSocketService
+	filter           *services.ContentFilter
+	storage          services.Storage
+	attachments      services.AttachmentPolicy
+	strangerLimit    int
+	editWindow       time.Duration
 }
 
-func NewMessageHandler(db *gorm.DB, websocketService *services.WebSocketService) *MessageHandler {
+// NewMessageHandler returns a MessageHandler. websocketService may be nil
+// to skip real-time delivery. A user can start MESSAGE_STRANGER_LIMIT
+// unanswered conversations with users they never talked to per hour
+// (default 10, 0 disables the limit). Senders can edit their messages for
+// MESSAGE_EDIT_WINDOW_MINUTES after sending them (default 15).
+func NewMessageHandler(db *gorm.DB, websocketService *services.WebSocketService, storage services.Storage) *MessageHandler {
+	strangerLimit, err := strconv.Atoi(os.Getenv("MESSAGE_STRANGER_LIMIT"))
+	if err != nil || strangerLimit < 0 {
+		strangerLimit = 10
+	}
+	editMinutes, err := strconv.Atoi(os.Getenv("MESSAGE_EDIT_WINDOW_MINUTES"))
+	if err != nil || editMinutes < 0 {
+		editMinutes = 15
+	}
 	return &MessageHandler{
 		db:               db,
 		websocketService: websocketService,
+		filter:           services.ContentFilterFromEnv(),
+		storage:          storage,
+		attachments:      services.AttachmentPolicyFromEnv(),
+		strangerLimit:    strangerLimit,
+		editWindow:       time.Duration(editMinutes) * time.Minute,
+	}
+}
+
+// visibleMessages leaves out messages hidden by moderation, except for their
+// sender, and messages the user deleted for themselves.
+func visibleMessages(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("messages.is_hidden = ? OR messages.from_user_id = ?", false, userID).
+			Where("NOT (messages.from_user_id = ? AND messages.deleted_for_sender = ?)", userID, true).
+			Where("NOT (messages.to_user_id = ? AND messages.deleted_for_recipient = ?)", userID, true)
 	}
 }
 
+// pushAllowed reports whether real-time events of a conversation may be
+// pushed to the user, who gets none for conversations they muted.
+func (h *MessageHandler) pushAllowed(conversationID, userID uint) bool {
+	if h.websocketService == nil {
+		return false
+	}
+	muted, _ := services.IsConversationMuted(h.db, conversationID, userID)
+	return !muted
+}
+
+// SendMessageRequest starts or continues the conversation with ToUserID
+// about ProductID or OrderID, or replies in ConversationID. Sent as a
+// multipart form, the message can carry attachments.
 type SendMessageRequest struct {
-	ToUserID uint   `json:"to_user_id" binding:"required"`
-	Content  string `json:"content" binding:"required"`
+	ToUserID       uint   `json:"to_user_id" form:"to_user_id" binding:"required_without=ConversationID"`
+	ConversationID uint   `json:"conversation_id" form:"conversation_id"`
+	ProductID      *uint  `json:"product_id" form:"product_id"`
+	OrderID        *uint  `json:"order_id" form:"order_id"`
+	Content        string `json:"content" form:"content"`
+}
+
+type MarkConversationReadRequest struct {
+	UpToMessageID uint `json:"up_to_message_id" binding:"required"`
+}
+
+// defaultMessageLimit is the page size of a conversation unless limit is
+// given; limit is capped at maxPageLimit.
+const defaultMessageLimit = 50
+
+// messagePage is a page of a conversation. Before pages back from a
+// message ID and After forward from one; without either the page holds the
+// latest messages.
+type messagePage struct {
+	Limit  int
+	Before uint
+	After  uint
+}
+
+func parseMessagePage(c *gin.Context) (messagePage, error) {
+	page := messagePage{Limit: defaultMessageLimit}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return page, errors.New("Invalid limit")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		page.Limit = limit
+	}
+
+	messageID := func(name string) (uint, error) {
+		raw := c.Query(name)
+		if raw == "" {
+			return 0, nil
+		}
+		id, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil || id == 0 {
+			return 0, errors.New("Invalid " + name + " message ID")
+		}
+		return uint(id), nil
+	}
+	var err error
+	if page.Before, err = messageID("before"); err != nil {
+		return page, err
+	}
+	if page.After, err = messageID("after"); err != nil {
+		return page, err
+	}
+	if page.Before != 0 && page.After != 0 {
+		return page, errors.New("Use either before or after")
+	}
+	return page, nil
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
 	fromUserID := c.MustGet("user_id").(uint)
 
+	if c.ContentType() == "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.attachments.MaxCount)*h.attachments.MaxBytes+(1<<20))
+	}
 	var req SendMessageRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBind(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var files []*multipart.FileHeader
+	if form, err := c.MultipartForm(); err == nil {
+		files = form.File["attachments"]
+	}
+	if req.Content == "" && len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message needs content or attachments"})
+		return
+	}
+	if len(files) > h.attachments.MaxCount {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("A message can have at most %d attachments", h.attachments.MaxCount)})
+		return
+	}
+
+	if !h.filter.Allows(req.Content) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+
+	// Replies name the conversation, new conversations the recipient
+	var conversation *models.Conversation
+	toUserID := req.ToUserID
+	if req.ConversationID != 0 {
+		var ok bool
+		if conversation, ok = h.findConversation(c, fromUserID, req.ConversationID); !ok {
+			return
+		}
+		if err := h.db.Model(&models.ConversationParticipant{}).
+			Where("conversation_id = ? AND user_id <> ?", conversation.ID, fromUserID).
+			Select("user_id").Scan(&toUserID).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recipient"})
+			return
+		}
+	}
+
 	// Check if recipient exists
 	var toUser models.User
-	if err := h.db.First(&toUser, req.ToUserID).Error; err != nil {
+	if err := h.db.First(&toUser, toUserID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Recipient not found"})
 			return
@@ -50,30 +194,247 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 	}
 
 	// Don't allow sending message to yourself
-	if fromUserID == req.ToUserID {
+	if fromUserID == toUserID {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot send message to yourself"})
 		return
 	}
 
+	blockedByUser, blockedByRecipient, err := services.IsBlocked(h.db, fromUserID, toUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	if blockedByUser {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Unblock this user to message them"})
+		return
+	}
+	if blockedByRecipient {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot message this user"})
+		return
+	}
+
+	if conversation == nil {
+		if req.ProductID != nil && !h.productBetween(c, *req.ProductID, fromUserID, toUserID) {
+			return
+		}
+		if req.OrderID != nil && !h.orderBetween(c, *req.OrderID, fromUserID, toUserID) {
+			return
+		}
+		if !h.withinStrangerLimit(c, fromUserID, toUserID) {
+			return
+		}
+	}
+
+	attachments, failed, err := h.storeAttachments(files)
+	if err != nil {
+		status, message := attachmentErrorMessage(err)
+		c.JSON(status, gin.H{"error": message, "file": failed.Filename})
+		return
+	}
+
 	// Create message
 	message := models.Message{
-		FromUserID: fromUserID,
-		ToUserID:   req.ToUserID,
-		Content:    req.Content,
-		IsRead:     false,
+		FromUserID:  fromUserID,
+		ToUserID:    toUserID,
+		Content:     req.Content,
+		IsRead:      false,
+		Attachments: attachments,
 	}
 
-	if err := h.db.Create(&message).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if conversation == nil {
+			var err error
+			if conversation, err = services.FindOrCreateConversation(tx, fromUserID, toUserID, req.ProductID, req.OrderID); err != nil {
+				return err
+			}
+		}
+		message.ConversationID = conversation.ID
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+		return tx.Model(conversation).Update("last_message_at", message.CreatedAt).Error
+	}); err != nil {
+		h.deleteAttachments(attachments)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
 		return
 	}
 
-	// Send real-time message via WebSocket
-	h.websocketService.SendPrivateMessage(fromUserID, req.ToUserID, req.Content)
+	// Send real-time message via WebSocket, attachments as a separate event,
+	// unless the recipient muted the conversation
+	if h.pushAllowed(message.ConversationID, toUserID) {
+		h.websocketService.SendPrivateMessage(fromUserID, toUserID, req.Content)
+		if len(message.Attachments) > 0 {
+			h.websocketService.SendNotification(toUserID, "message_attachment", gin.H{
+				"message_id":      message.ID,
+				"conversation_id": message.ConversationID,
+				"attachments":     message.Attachments,
+			})
+		}
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": message})
 }
 
+// withinStrangerLimit checks that the user may start a conversation with
+// otherID, writing the error response when they have started too many
+// unanswered conversations with users they never talked to in the past
+// hour.
+func (h *MessageHandler) withinStrangerLimit(c *gin.Context, userID, otherID uint) bool {
+	if h.strangerLimit == 0 {
+		return true
+	}
+	conversed, err := services.HaveConversed(h.db, userID, otherID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return false
+	}
+	if conversed {
+		return true
+	}
+	started, err := services.CountUnansweredConversations(h.db, userID, time.Now().Add(-time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return false
+	}
+	if started >= int64(h.strangerLimit) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many new conversations, wait for replies before starting more"})
+		return false
+	}
+	return true
+}
+
+// productBetween checks that a product a new conversation is about is sold
+// by one of its participants.
+func (h *MessageHandler) productBetween(c *gin.Context, productID, userID, otherID uint) bool {
+	var product models.Product
+	if err := h.db.First(&product, productID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return false
+	}
+	if product.UserID != userID && product.UserID != otherID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The product is not sold by either participant"})
+		return false
+	}
+	return true
+}
+
+// orderBetween checks that an order a new conversation is about was placed
+// by one participant and contains products of the other.
+func (h *MessageHandler) orderBetween(c *gin.Context, orderID, userID, otherID uint) bool {
+	var order models.Order
+	if err := h.db.Where("id = ? AND user_id IN ?", orderID, []uint{userID, otherID}).First(&order).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return false
+	}
+
+	sellerID := userID
+	if order.UserID == userID {
+		sellerID = otherID
+	}
+	var items int64
+	if err := h.db.Model(&models.OrderItem{}).
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND products.user_id = ?", order.ID, sellerID).
+		Count(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order"})
+		return false
+	}
+	if items == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The order has no products of the other participant"})
+		return false
+	}
+	return true
+}
+
+// messagesBetween matches the messages between two users across all their
+// conversations.
+func messagesBetween(userID, otherID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)",
+			userID, otherID, otherID, userID)
+	}
+}
+
+// fetchMessagePage returns the page of the messages matched by scope that
+// the user can see, oldest first, along with its pagination envelope.
+func (h *MessageHandler) fetchMessagePage(userID uint, scope func(db *gorm.DB) *gorm.DB, page messagePage) ([]models.Message, gin.H, error) {
+	visible := func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(scope, visibleMessages(userID))
+	}
+
+	// Fetch one extra message to know whether more follow in the paging
+	// direction, then return the page oldest first
+	query := h.db.Preload("FromUser", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name")
+	}).Preload("ToUser", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name")
+	}).Preload("Attachments").Scopes(visible).Limit(page.Limit + 1)
+	if page.After != 0 {
+		query = query.Where("id > ?", page.After).Order("id ASC")
+	} else {
+		if page.Before != 0 {
+			query = query.Where("id < ?", page.Before)
+		}
+		query = query.Order("id DESC")
+	}
+
+	var messages []models.Message
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, nil, err
+	}
+	more := len(messages) > page.Limit
+	if more {
+		messages = messages[:page.Limit]
+	}
+	if page.After == 0 {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	// Older messages exist before a page fetched with after, and newer ones
+	// after a page fetched with before
+	pagination := gin.H{"limit": page.Limit, "has_older": more && page.After == 0, "has_newer": more && page.After != 0}
+	if len(messages) > 0 {
+		oldest, newest := messages[0].ID, messages[len(messages)-1].ID
+		pagination["oldest_id"] = oldest
+		pagination["newest_id"] = newest
+		if page.After != 0 {
+			var older int64
+			if err := h.db.Model(&models.Message{}).Scopes(visible).Where("id < ?", oldest).Count(&older).Error; err != nil {
+				return nil, nil, err
+			}
+			pagination["has_older"] = older > 0
+		} else if page.Before != 0 {
+			var newer int64
+			if err := h.db.Model(&models.Message{}).Scopes(visible).Where("id > ?", newest).Count(&newer).Error; err != nil {
+				return nil, nil, err
+			}
+			pagination["has_newer"] = newer > 0
+		}
+	}
+	return messages, pagination, nil
+}
+
+// markMessagesRead marks the messages matched by scope that were sent to
+// the user, up to and including upToMessageID, as read.
+func (h *MessageHandler) markMessagesRead(userID uint, scope func(db *gorm.DB) *gorm.DB, upToMessageID uint) (int64, error) {
+	result := h.db.Model(&models.Message{}).Scopes(scope).
+		Where("to_user_id = ? AND id <= ? AND is_read = ?", userID, upToMessageID, false).
+		Update("is_read", true)
+	return result.RowsAffected, result.Error
+}
+
+// GetConversation lists the messages with another user across all
+// conversations with them.
 func (h *MessageHandler) GetConversation(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 	otherUserID := c.Param("user_id")
@@ -94,93 +455,55 @@ func (h *MessageHandler) GetConversation(c *gin.Context) {
 		return
 	}
 
-	// Get messages between the two users
-	var messages []models.Message
-	if err := h.db.Preload("FromUser", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id, first_name, last_name")
-	}).Preload("ToUser", func(db *gorm.DB) *gorm.DB {
-		return db.Select("id, first_name, last_name")
-	}).Where("(from_user_id = ? AND to_user_id = ?) OR (from_user_id = ? AND to_user_id = ?)",
-		userID, otherID, otherID, userID).Order("created_at ASC").Find(&messages).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+	page, err := parseMessagePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Mark messages as read
-	if err := h.db.Model(&models.Message{}).
-		Where("from_user_id = ? AND to_user_id = ? AND is_read = ?", otherID, userID, false).
-		Update("is_read", true).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
+	messages, pagination, err := h.fetchMessagePage(userID, messagesBetween(userID, uint(otherID)), page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	c.JSON(http.StatusOK, gin.H{
+		"messages":   messages,
+		"pagination": pagination,
+	})
 }
 
-func (h *MessageHandler) GetConversations(c *gin.Context) {
+// MarkConversationRead marks the messages the other user sent up to and
+// including up_to_message_id as read.
+func (h *MessageHandler) MarkConversationRead(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
-
-	// Get all conversations (users with whom the current user has exchanged messages)
-	var conversations []gin.H
-	rows, err := h.db.Raw(`
-		SELECT DISTINCT 
-			u.id, u.first_name, u.last_name, u.email,
-			(SELECT content FROM messages 
-			 WHERE ((from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?))
-			 ORDER BY created_at DESC LIMIT 1) as last_message,
-			(SELECT created_at FROM messages 
-			 WHERE ((from_user_id = ? AND to_user_id = u.id) OR (from_user_id = u.id AND to_user_id = ?))
-			 ORDER BY created_at DESC LIMIT 1) as last_message_time,
-			(SELECT COUNT(*) FROM messages 
-			 WHERE from_user_id = u.id AND to_user_id = ? AND is_read = false) as unread_count
-		FROM users u
-		WHERE u.id IN (
-			SELECT DISTINCT 
-				CASE 
-					WHEN from_user_id = ? THEN to_user_id 
-					ELSE from_user_id 
-				END
-			FROM messages 
-			WHERE from_user_id = ? OR to_user_id = ?
-		)
-		ORDER BY last_message_time DESC
-	`, userID, userID, userID, userID, userID, userID, userID, userID).Rows()
-
+	otherID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var id uint
-		var firstName, lastName, email, lastMessage string
-		var lastMessageTime time.Time
-		var unreadCount int
-
-		if err := rows.Scan(&id, &firstName, &lastName, &email, &lastMessage, &lastMessageTime, &unreadCount); err != nil {
-			continue
-		}
+	var req MarkConversationReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		conversations = append(conversations, gin.H{
-			"user_id":           id,
-			"first_name":        firstName,
-			"last_name":         lastName,
-			"email":             email,
-			"last_message":      lastMessage,
-			"last_message_time": lastMessageTime,
-			"unread_count":      unreadCount,
-		})
+	marked, err := h.markMessagesRead(userID, messagesBetween(userID, uint(otherID)), req.UpToMessageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
 }
 
 func (h *MessageHandler) GetUnreadCount(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
 	var count int64
-	if err := h.db.Model(&models.Message{}).Where("to_user_id = ? AND is_read = ?", userID, false).Count(&count).Error; err != nil {
+	if err := h.db.Model(&models.Message{}).Where("to_user_id = ? AND is_read = ? AND is_hidden = ? AND deleted_for_recipient = ?", userID, false, false, false).
+		Scopes(notFromBlocked(userID)).Count(&count).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get unread count"})
 		return
 	}
@@ -204,20 +527,3 @@ func (h *MessageHandler) MarkAsRead(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Message marked as read"})
 }
-
-func (h *MessageHandler) DeleteMessage(c *gin.Context) {
-	userID := c.MustGet("user_id").(uint)
-	messageID := c.Param("id")
-	id, err := strconv.ParseUint(messageID, 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
-		return
-	}
-
-	if err := h.db.Where("id = ? AND from_user_id = ?", id, userID).Delete(&models.Message{}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
-}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// attachmentErrorMessage maps attachment validation errors to client
+// messages.
+func attachmentErrorMessage(err error) (int, string) {
+	switch err {
+	case services.ErrAttachmentTooLarge, services.ErrImageTooLarge:
+		return http.StatusRequestEntityTooLarge, "Attachment is too large"
+	case services.ErrUnsupportedAttachmentType:
+		return http.StatusBadRequest, "Unsupported attachment type, use JPEG, PNG, GIF or PDF"
+	}
+	return http.StatusInternalServerError, "Failed to store attachment"
+}
+
+// storeAttachment validates an uploaded file and writes it to storage
+// outside the public uploads.
+func (h *MessageHandler) storeAttachment(fileHeader *multipart.FileHeader) (*models.MessageAttachment, error) {
+	if fileHeader.Size > h.attachments.MaxBytes {
+		return nil, services.ErrAttachmentTooLarge
+	}
+	data, err := readUpload(fileHeader, h.attachments.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	contentType, extension, err := h.attachments.Check(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := services.NewStorageKey("messages", extension)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.storage.Put(key, data, contentType); err != nil {
+		return nil, err
+	}
+	return &models.MessageAttachment{
+		FileName:    filepath.Base(fileHeader.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  key,
+	}, nil
+}
+
+// storeAttachments stores uploaded files, leaving nothing in storage when
+// one of them fails.
+func (h *MessageHandler) storeAttachments(files []*multipart.FileHeader) ([]models.MessageAttachment, *multipart.FileHeader, error) {
+	attachments := make([]models.MessageAttachment, 0, len(files))
+	for _, fileHeader := range files {
+		attachment, err := h.storeAttachment(fileHeader)
+		if err != nil {
+			h.deleteAttachments(attachments)
+			return nil, fileHeader, err
+		}
+		attachments = append(attachments, *attachment)
+	}
+	return attachments, nil, nil
+}
+
+// deleteAttachments removes stored attachments from storage.
+func (h *MessageHandler) deleteAttachments(attachments []models.MessageAttachment) {
+	for _, attachment := range attachments {
+		deleteStoredObjects(h.storage, attachment.StorageKey)
+	}
+}
+
+// DownloadAttachment streams a message attachment to the sender or the
+// recipient of the message, unless they deleted it for themselves.
+func (h *MessageHandler) DownloadAttachment(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	attachmentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID"})
+		return
+	}
+
+	var attachment models.MessageAttachment
+	if err := h.db.Joins("JOIN messages ON messages.id = message_attachments.message_id AND messages.deleted_at IS NULL").
+		Where("message_attachments.id = ?", attachmentID).
+		Where("messages.from_user_id = ? OR messages.to_user_id = ?", userID, userID).
+		Scopes(visibleMessages(userID)).
+		First(&attachment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachment"})
+		return
+	}
+
+	reader, _, err := h.storage.Get(attachment.StorageKey)
+	if err != nil {
+		if err == services.ErrObjectNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+	defer reader.Close()
+
+	c.Header("Content-Type", attachment.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.FileName}))
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, reader)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type BlockUserRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+// notFromBlocked leaves out messages from users the user blocked.
+func notFromBlocked(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("from_user_id NOT IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.UserBlock{}).
+			Select("blocked_user_id").Where("user_id = ?", userID))
+	}
+}
+
+// withoutBlocked leaves out conversations with users the user blocked.
+func withoutBlocked(userID uint) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("NOT EXISTS (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.ConversationParticipant{}).Select("1").
+			Joins("JOIN user_blocks ON user_blocks.blocked_user_id = conversation_participants.user_id AND user_blocks.user_id = ?", userID).
+			Where("conversation_participants.conversation_id = conversations.id"))
+	}
+}
+
+// isMuted reports whether the user muted a conversation with preloaded
+// participants.
+func isMuted(conversation *models.Conversation, userID uint) bool {
+	for _, participant := range conversation.Participants {
+		if participant.UserID == userID {
+			return participant.MutedAt != nil
+		}
+	}
+	return false
+}
+
+// BlockUser stops a user from messaging the current user and hides their
+// conversations from the inbox.
+func (h *MessageHandler) BlockUser(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req BlockUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot block yourself"})
+		return
+	}
+
+	var blocked models.User
+	if err := h.db.First(&blocked, req.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.UserBlock{}).Where("user_id = ? AND blocked_user_id = ?", userID, req.UserID).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already blocked"})
+		return
+	}
+
+	block := models.UserBlock{UserID: userID, BlockedUserID: req.UserID}
+	if err := h.db.Create(&block).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"block": block})
+}
+
+// UnblockUser lets a blocked user message the current user again.
+func (h *MessageHandler) UnblockUser(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	blockedUserID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	result := h.db.Where("user_id = ? AND blocked_user_id = ?", userID, blockedUserID).Delete(&models.UserBlock{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not blocked"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked successfully"})
+}
+
+// GetBlockedUsers lists the users the current user blocked.
+func (h *MessageHandler) GetBlockedUsers(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var blocks []models.UserBlock
+	if err := h.db.Preload("BlockedUser", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name")
+	}).Where("user_id = ?", userID).Order("created_at DESC").Find(&blocks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch blocked users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+// MuteConversation stops real-time pushes to the user for a conversation.
+func (h *MessageHandler) MuteConversation(c *gin.Context) {
+	h.setConversationMuted(c, true)
+}
+
+// UnmuteConversation resumes real-time pushes for a conversation.
+func (h *MessageHandler) UnmuteConversation(c *gin.Context) {
+	h.setConversationMuted(c, false)
+}
+
+func (h *MessageHandler) setConversationMuted(c *gin.Context, muted bool) {
+	userID := c.MustGet("user_id").(uint)
+	conversationID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conversation, ok := h.findConversation(c, userID, uint(conversationID))
+	if !ok {
+		return
+	}
+
+	var mutedAt *time.Time
+	if muted {
+		now := time.Now()
+		mutedAt = &now
+	}
+	if err := h.db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ? AND user_id = ?", conversation.ID, userID).
+		Update("muted_at", mutedAt).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation_id": conversation.ID, "muted": muted})
+}
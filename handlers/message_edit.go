@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// findOwnMessage loads a message the user sent or received and can still
+// see, writing the error response when there is none.
+func (h *MessageHandler) findOwnMessage(c *gin.Context, userID uint) (*models.Message, bool) {
+	messageID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return nil, false
+	}
+
+	var message models.Message
+	if err := h.db.Where("from_user_id = ? OR to_user_id = ?", userID, userID).
+		Scopes(visibleMessages(userID)).First(&message, messageID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+		return nil, false
+	}
+	return &message, true
+}
+
+// EditMessage changes the content of a message its sender sent within the
+// edit window, keeping the previous content in the edit history.
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, ok := h.findOwnMessage(c, userID)
+	if !ok {
+		return
+	}
+	if message.FromUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can edit a message"})
+		return
+	}
+	if message.IsHidden {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Hidden messages cannot be edited"})
+		return
+	}
+	if time.Since(message.CreatedAt) > h.editWindow {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Messages can only be edited within %d minutes of sending", int(h.editWindow.Minutes()))})
+		return
+	}
+	if req.Content == message.Content {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is unchanged"})
+		return
+	}
+	if !h.filter.Allows(req.Content) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+
+	now := time.Now()
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.MessageEdit{MessageID: message.ID, Content: message.Content}).Error; err != nil {
+			return err
+		}
+		return tx.Model(message).Updates(map[string]interface{}{"content": req.Content, "edited_at": now}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+	message.Content = req.Content
+	message.EditedAt = &now
+
+	if h.pushAllowed(message.ConversationID, message.ToUserID) {
+		h.websocketService.SendNotification(message.ToUserID, "message_edited", gin.H{
+			"message_id":      message.ID,
+			"conversation_id": message.ConversationID,
+			"content":         message.Content,
+			"edited_at":       message.EditedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// GetMessageEdits returns the previous contents of a message, oldest first.
+func (h *MessageHandler) GetMessageEdits(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	message, ok := h.findOwnMessage(c, userID)
+	if !ok {
+		return
+	}
+
+	var edits []models.MessageEdit
+	if err := h.db.Where("message_id = ?", message.ID).Order("id ASC").Find(&edits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch edits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "edits": edits})
+}
+
+// DeleteMessage deletes a message for the user only, or with for=everyone
+// unsends it for both participants, which only its sender can do.
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	scope := c.DefaultQuery("for", "me")
+	if scope != "me" && scope != "everyone" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "for must be me or everyone"})
+		return
+	}
+
+	message, ok := h.findOwnMessage(c, userID)
+	if !ok {
+		return
+	}
+
+	event := gin.H{
+		"message_id":      message.ID,
+		"conversation_id": message.ConversationID,
+		"for":             scope,
+	}
+
+	if scope == "me" {
+		column := "deleted_for_recipient"
+		if message.FromUserID == userID {
+			column = "deleted_for_sender"
+		}
+		if err := h.db.Model(message).Update(column, true).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+		// Other sessions of the user drop the message too
+		if h.websocketService != nil {
+			h.websocketService.SendNotification(userID, "message_deleted", event)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
+		return
+	}
+
+	if message.FromUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can unsend a message"})
+		return
+	}
+
+	var attachments []models.MessageAttachment
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ?", message.ID).Find(&attachments).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageAttachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(message).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsend message"})
+		return
+	}
+	h.deleteAttachments(attachments)
+
+	if h.websocketService != nil {
+		h.websocketService.SendNotification(userID, "message_deleted", event)
+	}
+	if h.pushAllowed(message.ConversationID, message.ToUserID) {
+		h.websocketService.SendNotification(message.ToUserID, "message_deleted", event)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message unsent successfully"})
+}
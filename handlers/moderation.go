@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ModerationHandler struct {
+	db      *gorm.DB
+	storage services.Storage
+}
+
+func NewModerationHandler(db *gorm.DB, storage services.Storage) *ModerationHandler {
+	return &ModerationHandler{db: db, storage: storage}
+}
+
+type ReportContentRequest struct {
+	ContentType models.ContentType `json:"content_type" binding:"required,oneof=review product message"`
+	ContentID   uint               `json:"content_id" binding:"required"`
+	Reason      string             `json:"reason" binding:"required,max=500"`
+}
+
+type ModerateContentRequest struct {
+	Action models.ModerationActionType `json:"action" binding:"required,oneof=approve hide delete"`
+	Reason string                      `json:"reason" binding:"max=500"`
+}
+
+// errContentFiltered is the response to text with banned words.
+const errContentFiltered = "Content contains words that are not allowed"
+
+// moderatedContent is a reported review, product or message together with
+// the facts moderation needs about it.
+type moderatedContent struct {
+	Content     interface{}
+	AuthorID    uint
+	RecipientID uint // only set for messages
+	Hidden      bool
+	Deleted     bool
+}
+
+// loadModeratedContent loads content including deleted content.
+func loadModeratedContent(db *gorm.DB, contentType models.ContentType, contentID uint) (*moderatedContent, error) {
+	db = db.Unscoped()
+	switch contentType {
+	case models.ContentTypeReview:
+		var review models.Review
+		if err := db.First(&review, contentID).Error; err != nil {
+			return nil, err
+		}
+		return &moderatedContent{Content: review, AuthorID: review.UserID, Hidden: review.IsHidden, Deleted: review.DeletedAt.Valid}, nil
+	case models.ContentTypeProduct:
+		var product models.Product
+		if err := db.First(&product, contentID).Error; err != nil {
+			return nil, err
+		}
+		return &moderatedContent{Content: product, AuthorID: product.UserID, Hidden: product.IsHidden, Deleted: product.DeletedAt.Valid}, nil
+	case models.ContentTypeMessage:
+		var message models.Message
+		if err := db.First(&message, contentID).Error; err != nil {
+			return nil, err
+		}
+		return &moderatedContent{
+			Content:     message,
+			AuthorID:    message.FromUserID,
+			RecipientID: message.ToUserID,
+			Hidden:      message.IsHidden,
+			Deleted:     message.DeletedAt.Valid,
+		}, nil
+	}
+	return nil, services.ErrUnknownContentType
+}
+
+// ReportContent reports a review, product or message. Messages can only be
+// reported by their recipient. Content with services.AutoHideThreshold open
+// reports is hidden until a moderator decides.
+func (h *ModerationHandler) ReportContent(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req ReportContentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := loadModeratedContent(h.db, req.ContentType, req.ContentID)
+	if err == nil && (content.Deleted || (req.ContentType == models.ContentTypeMessage && content.RecipientID != userID && content.AuthorID != userID)) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch content"})
+		return
+	}
+	if content.AuthorID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot report your own content"})
+		return
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.ContentReport{}).
+		Where("content_type = ? AND content_id = ? AND reporter_id = ?", req.ContentType, req.ContentID, userID).
+		Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check reports"})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already reported this content"})
+		return
+	}
+
+	report := models.ContentReport{
+		ContentType: req.ContentType,
+		ContentID:   req.ContentID,
+		ReporterID:  userID,
+		Reason:      req.Reason,
+		Status:      models.ContentReportOpen,
+	}
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+
+		threshold := services.AutoHideThreshold()
+		if threshold == 0 || content.Hidden {
+			return nil
+		}
+		var open int64
+		if err := tx.Model(&models.ContentReport{}).
+			Where("content_type = ? AND content_id = ? AND status = ?", req.ContentType, req.ContentID, models.ContentReportOpen).
+			Count(&open).Error; err != nil {
+			return err
+		}
+		if int(open) < threshold {
+			return nil
+		}
+		if err := services.SetContentHidden(tx, req.ContentType, req.ContentID, true); err != nil {
+			return err
+		}
+		return tx.Create(&models.ModerationAction{
+			ContentType: req.ContentType,
+			ContentID:   req.ContentID,
+			Action:      models.ModerationAutoHide,
+			Reason:      "Reached " + strconv.Itoa(threshold) + " open reports",
+			ReportCount: int(open),
+		}).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to report content"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"report": report})
+}
+
+// GetModerationQueue lists reported content with open reports, the most
+// reported first.
+func (h *ModerationHandler) GetModerationQueue(c *gin.Context) {
+	pageReq, err := parsePageRequest(c)
+	if err != nil || pageReq.Cursor != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The moderation queue only supports page"})
+		return
+	}
+
+	grouped := h.db.Model(&models.ContentReport{}).
+		Select("content_type, content_id, COUNT(*) AS report_count, MIN(id) AS first_report_id").
+		Where("status = ?", models.ContentReportOpen).
+		Group("content_type, content_id")
+	if contentType := c.Query("content_type"); contentType != "" {
+		grouped = grouped.Where("content_type = ?", contentType)
+	}
+
+	var total int64
+	if err := h.db.Table("(?) AS queue", grouped).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count queue"})
+		return
+	}
+
+	var rows []struct {
+		ContentType   models.ContentType
+		ContentID     uint
+		ReportCount   int
+		FirstReportID uint
+	}
+	if err := grouped.Order("report_count DESC, first_report_id ASC").
+		Offset((pageReq.Page - 1) * pageReq.Limit).Limit(pageReq.Limit).
+		Scan(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch queue"})
+		return
+	}
+
+	items := []gin.H{}
+	for _, row := range rows {
+		item := gin.H{
+			"content_type": row.ContentType,
+			"content_id":   row.ContentID,
+			"report_count": row.ReportCount,
+		}
+		if content, err := loadModeratedContent(h.db, row.ContentType, row.ContentID); err == nil {
+			item["content"] = content.Content
+			item["hidden"] = content.Hidden
+			item["deleted"] = content.Deleted
+		}
+
+		var reports []models.ContentReport
+		if err := h.db.Preload("Reporter", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, first_name, last_name")
+		}).Where("content_type = ? AND content_id = ? AND status = ?", row.ContentType, row.ContentID, models.ContentReportOpen).
+			Order("created_at ASC").Find(&reports).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reports"})
+			return
+		}
+		item["reports"] = reports
+		items = append(items, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":      items,
+		"pagination": paginationResponse(pageReq, total, ""),
+	})
+}
+
+// ModerateContent approves, hides or deletes reported content, resolves its
+// open reports and records the decision in the audit trail. Approving shows
+// hidden content again.
+func (h *ModerationHandler) ModerateContent(c *gin.Context) {
+	moderatorID := c.MustGet("user_id").(uint)
+	contentType := models.ContentType(c.Param("type"))
+	contentID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content ID"})
+		return
+	}
+
+	var req ModerateContentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := loadModeratedContent(h.db, contentType, uint(contentID))
+	if err != nil {
+		switch err {
+		case services.ErrUnknownContentType:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid content type"})
+		case gorm.ErrRecordNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Content not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch content"})
+		}
+		return
+	}
+	if content.Deleted && req.Action != models.ModerationDelete {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content has been deleted"})
+		return
+	}
+
+	action := models.ModerationAction{
+		ContentType: contentType,
+		ContentID:   uint(contentID),
+		Action:      req.Action,
+		ModeratorID: &moderatorID,
+		Reason:      req.Reason,
+	}
+	var storedKeys []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		switch {
+		case content.Deleted:
+			// Deleted by its author already, only the reports are left
+		case req.Action == models.ModerationDelete:
+			if storedKeys, err = services.DeleteContent(tx, contentType, uint(contentID)); err == nil && contentType == models.ContentTypeReview {
+				storedKeys, err = deleteReviewPhotos(tx, uint(contentID))
+			}
+		default:
+			err = services.SetContentHidden(tx, contentType, uint(contentID), req.Action == models.ModerationHide)
+		}
+		if err != nil {
+			return err
+		}
+
+		if action.ReportCount, err = services.ResolveReports(tx, contentType, uint(contentID)); err != nil {
+			return err
+		}
+		return tx.Create(&action).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to moderate content"})
+		return
+	}
+	deleteStoredObjects(h.storage, storedKeys...)
+
+	c.JSON(http.StatusOK, gin.H{"action": action})
+}
+
+// GetModerationLog lists moderation decisions, newest first, optionally for
+// one content_type and content_id.
+func (h *ModerationHandler) GetModerationLog(c *gin.Context) {
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		if contentType := c.Query("content_type"); contentType != "" {
+			db = db.Where("content_type = ?", contentType)
+		}
+		if contentID := c.Query("content_id"); contentID != "" {
+			db = db.Where("content_id = ?", contentID)
+		}
+		return db
+	}
+
+	var total int64
+	if err := h.db.Model(&models.ModerationAction{}).Scopes(filter).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count moderation actions"})
+		return
+	}
+
+	sort := createdAtSort("moderation_actions", true)
+	query, err := paginate(h.db.Preload("Moderator", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id, first_name, last_name")
+	}).Scopes(filter), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var actions []models.ModerationAction
+	if err := query.Find(&actions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation actions"})
+		return
+	}
+
+	var nextCursor string
+	if len(actions) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.ModerationAction{}, actions[len(actions)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"actions":    actions,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
+}
@@ -15,17 +15,24 @@ import (
 type OrderHandler struct {
 	db             *gorm.DB
 	paymentService *services.PaymentService
+	alerts         *services.ProductAlertService
 }
 
-func NewOrderHandler(db *gorm.DB, paymentService *services.PaymentService) *OrderHandler {
+// NewOrderHandler returns an OrderHandler. alerts is told about the stock
+// cancelled orders return.
+func NewOrderHandler(db *gorm.DB, paymentService *services.PaymentService, alerts *services.ProductAlertService) *OrderHandler {
 	return &OrderHandler{
 		db:             db,
 		paymentService: paymentService,
+		alerts:         alerts,
 	}
 }
 
 type CreateOrderRequest struct {
 	ShippingAddress string `json:"shipping_address" binding:"required"`
+	// AcknowledgeChanges accepts the current prices of cart items whose
+	// price changed since they were added
+	AcknowledgeChanges bool `json:"acknowledge_changes"`
 }
 
 type UpdateOrderStatusRequest struct {
@@ -43,7 +50,7 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 
 	// Get user's cart
 	var cart models.Cart
-	if err := h.db.Preload("CartItems.Product").Where("user_id = ?", userID).First(&cart).Error; err != nil {
+	if err := preloadCartItems(h.db).Where("user_id = ?", userID).First(&cart).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
 			return
@@ -57,15 +64,29 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		return
 	}
 
-	// Calculate total and validate stock
+	// Refuse checkout when items changed since the buyer added them, unless
+	// the only changes are prices the buyer has acknowledged
+	warnings, err := cartWarnings(h.db, cart)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cart items"})
+		return
+	}
+	for _, warning := range warnings {
+		if warning.blocking() {
+			c.JSON(http.StatusConflict, gin.H{"error": "Some cart items can no longer be ordered", "warnings": warnings})
+			return
+		}
+	}
+	if len(warnings) > 0 && !req.AcknowledgeChanges {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cart prices changed, confirm with acknowledge_changes", "warnings": warnings})
+		return
+	}
+
+	// Calculate total
 	var totalAmount float64
 
 	for _, item := range cart.CartItems {
-		if item.Product.Stock < item.Quantity {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock for product: " + item.Product.Name})
-			return
-		}
-		totalAmount += item.Product.Price * float64(item.Quantity)
+		totalAmount += item.UnitPrice() * float64(item.Quantity)
 	}
 
 	// Create order
@@ -76,30 +97,65 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		ShippingAddress: req.ShippingAddress,
 	}
 
-	if err := h.db.Create(&order).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-		return
-	}
+	// Create the order, its items and the matching sales in the inventory
+	// ledger together, so a sale that would oversell rolls everything back
+	var outOfStock string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
 
-	// Create order items and update stock
-	for _, item := range cart.CartItems {
-		orderItem := models.OrderItem{
-			OrderID:   order.ID,
-			ProductID: item.ProductID,
-			Quantity:  item.Quantity,
-			Price:     item.Product.Price,
+		for _, item := range cart.CartItems {
+			orderItem := models.OrderItem{
+				OrderID:   order.ID,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				Price:     item.UnitPrice(),
+			}
+			if item.Variant != nil && item.Variant.SKU != nil {
+				orderItem.SKU = *item.Variant.SKU
+			}
+			if err := tx.Create(&orderItem).Error; err != nil {
+				return err
+			}
+
+			// Stock reserved by other carts is off limits, this cart's own
+			// reservation is converted into the sale
+			available, err := services.AvailableStock(tx, item.ProductID, item.VariantID, cart.ID)
+			if err != nil {
+				return err
+			}
+			if available < item.Quantity {
+				outOfStock = item.Product.Name
+				return services.ErrInsufficientStock
+			}
+
+			if _, err := services.RecordStockMovement(tx, services.StockMovement{
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Type:      models.InventoryMovementSale,
+				Quantity:  -item.Quantity,
+				ActorID:   &userID,
+				OrderID:   &order.ID,
+			}); err != nil {
+				if err == services.ErrInsufficientStock {
+					outOfStock = item.Product.Name
+				}
+				return err
+			}
 		}
-		if err := h.db.Create(&orderItem).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order item"})
-			return
+		if err := services.RecordCartConversion(tx, cart.ID, order.ID); err != nil {
+			return err
 		}
-
-		// Update product stock
-		item.Product.Stock -= item.Quantity
-		if err := h.db.Save(&item.Product).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product stock"})
+		return tx.Where("cart_id = ?", cart.ID).Delete(&models.StockReservation{}).Error
+	}); err != nil {
+		if err == services.ErrInsufficientStock {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock for product: " + outOfStock})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return
 	}
 
 	// Create Stripe checkout session
@@ -140,13 +196,43 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 func (h *OrderHandler) GetOrders(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var total int64
+	if err := h.db.Model(&models.Order{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	sort := createdAtSort("orders", true)
+	query, err := paginate(h.db.Preload("OrderItems.Product").Preload("OrderItems.Variant").Where("user_id = ?", userID), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
 	var orders []models.Order
-	if err := h.db.Preload("OrderItems.Product").Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error; err != nil {
+	if err := query.Find(&orders).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"orders": orders})
+	var nextCursor string
+	if len(orders) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.Order{}, orders[len(orders)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":     orders,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
 }
 
 func (h *OrderHandler) GetOrder(c *gin.Context) {
@@ -159,7 +245,7 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 	}
 
 	var order models.Order
-	if err := h.db.Preload("OrderItems.Product").Where("id = ? AND user_id = ?", id, userID).First(&order).Error; err != nil {
+	if err := h.db.Preload("OrderItems.Product").Preload("OrderItems.Variant").Where("id = ? AND user_id = ?", id, userID).First(&order).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
 			return
@@ -174,19 +260,50 @@ func (h *OrderHandler) GetOrder(c *gin.Context) {
 func (h *OrderHandler) GetMyProductOrders(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
-	var orders []models.Order
-	if err := h.db.Preload("OrderItems.Product").Preload("User").
-		Joins("JOIN order_items ON orders.id = order_items.order_id").
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	// Orders containing at least one of the seller's products
+	sellerOrderIDs := h.db.Model(&models.OrderItem{}).
+		Select("order_items.order_id").
 		Joins("JOIN products ON order_items.product_id = products.id").
-		Where("products.user_id = ?", userID).
-		Group("orders.id").
-		Order("orders.created_at DESC").
-		Find(&orders).Error; err != nil {
+		Where("products.user_id = ?", userID)
+
+	var total int64
+	if err := h.db.Model(&models.Order{}).Where("orders.id IN (?)", sellerOrderIDs).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count orders"})
+		return
+	}
+
+	sort := createdAtSort("orders", true)
+	query, err := paginate(h.db.Preload("OrderItems.Product").Preload("User").
+		Where("orders.id IN (?)", sellerOrderIDs), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"orders": orders})
+	var nextCursor string
+	if len(orders) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.Order{}, orders[len(orders)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"orders":     orders,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
 }
 
 func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
@@ -219,13 +336,79 @@ func (h *OrderHandler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
+	// Cancelling restocks the items, so a cancelled order cannot be reopened
+	if order.Status == models.OrderStatusCancelled && req.Status != models.OrderStatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancelled orders cannot be reopened"})
+		return
+	}
+	cancelling := req.Status == models.OrderStatusCancelled && order.Status != models.OrderStatusCancelled
+
+	// Cancelling restocks every item, so a seller can only cancel orders
+	// made up entirely of their own products
+	if cancelling {
+		var otherSellersItems int64
+		if err := h.db.Model(&models.OrderItem{}).
+			Joins("JOIN products ON products.id = order_items.product_id").
+			Where("order_items.order_id = ? AND products.user_id <> ?", order.ID, userID).
+			Count(&otherSellersItems).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order items"})
+			return
+		}
+		if otherSellersItems > 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Orders with items from other sellers cannot be cancelled"})
+			return
+		}
+	}
+
 	// Update order status
 	order.Status = req.Status
-	if err := h.db.Save(&order).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("OrderItems").Save(&order).Error; err != nil {
+			return err
+		}
+		if order.Status == models.OrderStatusDelivered {
+			// Reviews written before delivery become verified purchases
+			productIDs := []uint{}
+			for _, item := range order.OrderItems {
+				productIDs = append(productIDs, item.ProductID)
+			}
+			if err := tx.Model(&models.Review{}).
+				Where("user_id = ? AND product_id IN ?", order.UserID, productIDs).
+				Update("verified_purchase", true).Error; err != nil {
+				return err
+			}
+		}
+		if !cancelling {
+			return nil
+		}
+		for _, item := range order.OrderItems {
+			if _, err := services.RecordStockMovement(tx, services.StockMovement{
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Type:      models.InventoryMovementCancellation,
+				Quantity:  item.Quantity,
+				ActorID:   &userID,
+				OrderID:   &order.ID,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
 		return
 	}
 
+	if cancelling {
+		restocked := map[uint]bool{}
+		for _, item := range order.OrderItems {
+			if !restocked[item.ProductID] {
+				restocked[item.ProductID] = true
+				h.alerts.StockChanged(item.ProductID)
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"order": order})
 }
 
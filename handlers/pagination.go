@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageRequest is the pagination requested by a listing endpoint, either by
+// page number or by an opaque keyset cursor returned as next_cursor.
+type pageRequest struct {
+	Page   int
+	Limit  int
+	Cursor *pageCursor
+}
+
+type pageCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// parsePageRequest reads page, limit and cursor from the query string.
+// The limit defaults to defaultPageLimit and is capped at maxPageLimit.
+func parsePageRequest(c *gin.Context) (pageRequest, error) {
+	req := pageRequest{Page: 1, Limit: defaultPageLimit}
+
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		req.Page = page
+	}
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 {
+		req.Limit = limit
+	}
+	if req.Limit > maxPageLimit {
+		req.Limit = maxPageLimit
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		data, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil {
+			return req, errInvalidCursor
+		}
+		var cursor pageCursor
+		if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == 0 {
+			return req, errInvalidCursor
+		}
+		req.Cursor = &cursor
+	}
+
+	return req, nil
+}
+
+// keysetSort orders a listing by an expression with the primary key as a
+// tie breaker, which keeps the order stable and lets a cursor resume right
+// after the last row of the previous page.
+type keysetSort struct {
+	Expr     string
+	IDColumn string
+	Desc     bool
+	Time     bool // Expr is a timestamp rather than a number
+}
+
+// createdAtSort orders the rows of table by creation time.
+func createdAtSort(table string, desc bool) keysetSort {
+	return keysetSort{Expr: table + ".created_at", IDColumn: table + ".id", Desc: desc, Time: true}
+}
+
+func (s keysetSort) direction() string {
+	if s.Desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (s keysetSort) parseValue(value string) (interface{}, error) {
+	if s.Time {
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Apply orders query by the sort and, when a cursor is given, restricts it
+// to rows after the cursor position.
+func (s keysetSort) Apply(query *gorm.DB, cursor *pageCursor) (*gorm.DB, error) {
+	if cursor != nil {
+		value, err := s.parseValue(cursor.Value)
+		if err != nil {
+			return nil, errInvalidCursor
+		}
+		op := ">"
+		if s.Desc {
+			op = "<"
+		}
+		query = query.Where("("+s.Expr+" "+op+" ? OR ("+s.Expr+" = ? AND "+s.IDColumn+" "+op+" ?))",
+			value, value, cursor.ID)
+	}
+
+	return query.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL: s.Expr + " " + s.direction() + ", " + s.IDColumn + " " + s.direction(),
+	}}), nil
+}
+
+// NextCursor returns the cursor pointing after the row with the given ID of
+// model, or an empty string when there is no such row.
+func (s keysetSort) NextCursor(db *gorm.DB, model interface{}, id uint) (string, error) {
+	var cursor pageCursor
+	if s.Time {
+		var value time.Time
+		if err := db.Model(model).Where(s.IDColumn+" = ?", id).Select(s.Expr).Row().Scan(&value); err != nil {
+			return "", err
+		}
+		cursor = pageCursor{Value: value.Format(time.RFC3339Nano), ID: id}
+	} else {
+		var value *float64
+		if err := db.Model(model).Where(s.IDColumn+" = ?", id).Select(s.Expr).Row().Scan(&value); err != nil {
+			return "", err
+		}
+		if value == nil {
+			value = new(float64)
+		}
+		cursor = pageCursor{Value: strconv.FormatFloat(*value, 'g', -1, 64), ID: id}
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// paginate applies the requested page to query. Cursor requests skip the
+// offset; page requests use it. The sort is always applied.
+func paginate(query *gorm.DB, sort keysetSort, req pageRequest) (*gorm.DB, error) {
+	query, err := sort.Apply(query, req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if req.Cursor == nil {
+		query = query.Offset((req.Page - 1) * req.Limit)
+	}
+	return query.Limit(req.Limit), nil
+}
+
+// paginationResponse builds the pagination envelope shared by listings.
+func paginationResponse(req pageRequest, total int64, nextCursor string) gin.H {
+	pagination := gin.H{
+		"limit": req.Limit,
+		"total": total,
+	}
+	if req.Cursor == nil {
+		pagination["page"] = req.Page
+	}
+	if nextCursor != "" {
+		pagination["next_cursor"] = nextCursor
+	}
+	return pagination
+}
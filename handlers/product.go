@@ -1,42 +1,151 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"ecommerce-app/models"
+	"ecommerce-app/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type ProductHandler struct {
-	db *gorm.DB
+	db      *gorm.DB
+	search  services.ProductSearch
+	storage services.Storage
+	images  *services.ImageProcessor
+	alerts  *services.ProductAlertService
+	filter  *services.ContentFilter
 }
 
-func NewProductHandler(db *gorm.DB) *ProductHandler {
-	return &ProductHandler{db: db}
+func NewProductHandler(db *gorm.DB, search services.ProductSearch, storage services.Storage, images *services.ImageProcessor, alerts *services.ProductAlertService) *ProductHandler {
+	return &ProductHandler{
+		db:      db,
+		search:  search,
+		storage: storage,
+		images:  images,
+		alerts:  alerts,
+		filter:  services.ContentFilterFromEnv(),
+	}
 }
 
 type CreateProductRequest struct {
+	SKU         string  `json:"sku"`
 	Name        string  `json:"name" binding:"required"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" binding:"required,gt=0"`
 	Stock       int     `json:"stock" binding:"required,gte=0"`
 	ImageURL    string  `json:"image_url"`
-	Category    string  `json:"category"`
+	CategoryID  *uint   `json:"category_id"`
 }
 
 type UpdateProductRequest struct {
+	SKU         string  `json:"sku"`
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" binding:"omitempty,gt=0"`
-	Stock       int     `json:"stock" binding:"omitempty,gte=0"`
+	Stock       *int    `json:"stock" binding:"omitempty,gte=0"`
+	StockReason string  `json:"stock_reason"`
 	ImageURL    string  `json:"image_url"`
-	Category    string  `json:"category"`
+	CategoryID  *uint   `json:"category_id"`
 	IsActive    *bool   `json:"is_active"`
 }
 
+type CreateProductOptionRequest struct {
+	Name     string   `json:"name" binding:"required"`
+	Position int      `json:"position"`
+	Values   []string `json:"values" binding:"required,min=1,dive,required"`
+}
+
+type CreateVariantRequest struct {
+	SKU            string   `json:"sku" binding:"required"`
+	Price          *float64 `json:"price" binding:"omitempty,gt=0"`
+	Stock          int      `json:"stock" binding:"gte=0"`
+	ImageURL       string   `json:"image_url"`
+	OptionValueIDs []uint   `json:"option_value_ids"`
+}
+
+type UpdateVariantRequest struct {
+	SKU         string   `json:"sku"`
+	Price       *float64 `json:"price" binding:"omitempty,gt=0"`
+	Stock       *int     `json:"stock" binding:"omitempty,gte=0"`
+	StockReason string   `json:"stock_reason"`
+	ImageURL    string   `json:"image_url"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// sellerSKUTaken reports whether another product of the seller already uses sku.
+func (h *ProductHandler) sellerSKUTaken(userID uint, sku string, exceptProductID uint) bool {
+	var count int64
+	h.db.Model(&models.Product{}).Where("user_id = ? AND sku = ? AND id <> ?", userID, sku, exceptProductID).Count(&count)
+	return count > 0
+}
+
+var errVariantRequired = errors.New("variant is required for this product")
+
+// loadVariant returns the active variant identified by variantID for the given
+// product. It returns nil when variantID is nil and the product has no active
+// variants, and errVariantRequired when the product does have variants.
+func loadVariant(db *gorm.DB, productID uint, variantID *uint) (*models.ProductVariant, error) {
+	if variantID == nil {
+		var count int64
+		if err := db.Model(&models.ProductVariant{}).
+			Where("product_id = ? AND is_active = ?", productID, true).
+			Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errVariantRequired
+		}
+		return nil, nil
+	}
+
+	var variant models.ProductVariant
+	if err := db.Where("id = ? AND product_id = ? AND is_active = ?", *variantID, productID, true).
+		First(&variant).Error; err != nil {
+		return nil, err
+	}
+	return &variant, nil
+}
+
+// variantMatrix flattens the variants of a product into option name/value
+// pairs together with their effective price and availability.
+func variantMatrix(product models.Product) []gin.H {
+	optionNames := make(map[uint]string)
+	for _, option := range product.Options {
+		for _, value := range option.Values {
+			optionNames[value.ID] = option.Name
+		}
+	}
+
+	matrix := make([]gin.H, 0, len(product.Variants))
+	for _, variant := range product.Variants {
+		options := make(map[string]string)
+		for _, value := range variant.OptionValues {
+			options[optionNames[value.ID]] = value.Value
+		}
+
+		imageURL := variant.ImageURL
+		if imageURL == "" {
+			imageURL = product.ImageURL
+		}
+
+		matrix = append(matrix, gin.H{
+			"variant_id": variant.ID,
+			"sku":        variant.SKU,
+			"options":    options,
+			"price":      variant.EffectivePrice(product),
+			"stock":      variant.Stock,
+			"image_url":  imageURL,
+			"available":  variant.IsActive && product.IsAvailable() && variant.Stock > 0,
+		})
+	}
+	return matrix
+}
+
 func (h *ProductHandler) CreateProduct(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
@@ -45,19 +154,58 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.filter.Allows(req.Name, req.Description) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+
+	if req.CategoryID != nil {
+		var category models.Category
+		if err := h.db.First(&category, *req.CategoryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	if req.SKU != "" && h.sellerSKUTaken(userID, req.SKU, 0) {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+		return
+	}
 
 	product := models.Product{
 		Name:        req.Name,
 		Description: req.Description,
 		Price:       req.Price,
-		Stock:       req.Stock,
 		ImageURL:    req.ImageURL,
-		Category:    req.Category,
+		CategoryID:  req.CategoryID,
 		UserID:      userID,
 		IsActive:    true,
 	}
+	if req.SKU != "" {
+		product.SKU = &req.SKU
+	}
 
-	if err := h.db.Create(&product).Error; err != nil {
+	// The initial stock goes through the ledger like any later restock
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&product).Error; err != nil {
+			return err
+		}
+		if req.Stock == 0 {
+			return nil
+		}
+		movement, err := services.RecordStockMovement(tx, services.StockMovement{
+			ProductID: product.ID,
+			Type:      models.InventoryMovementRestock,
+			Quantity:  req.Stock,
+			Reason:    "Initial stock",
+			ActorID:   &userID,
+		})
+		if err != nil {
+			return err
+		}
+		product.Stock = movement.StockAfter
+		return nil
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
 		return
 	}
@@ -66,53 +214,88 @@ func (h *ProductHandler) CreateProduct(c *gin.Context) {
 }
 
 func (h *ProductHandler) GetProducts(c *gin.Context) {
+	filters, err := h.parseProductFilters(c)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+		return
+	}
+
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
 	var products []models.Product
-	query := h.db.Preload("User", func(db *gorm.DB) *gorm.DB {
+	query := h.filterProducts(h.db.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id, first_name, last_name, email")
-	}).Preload("Reviews").Where("is_active = ?", true)
+	}).Preload("Category"), filters, "")
 
-	// Apply filters
-	if category := c.Query("category"); category != "" {
-		query = query.Where("category = ?", category)
+	var total int64
+	if err := h.filterProducts(h.db.Model(&models.Product{}), filters, "").Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count products"})
+		return
 	}
 
-	if minPrice := c.Query("min_price"); minPrice != "" {
-		if price, err := strconv.ParseFloat(minPrice, 64); err == nil {
-			query = query.Where("price >= ?", price)
-		}
+	// Search results default to relevance order, which only supports pages
+	sortParam := c.Query("sort")
+	if sortParam == "" && filters.Search == "" {
+		sortParam = "newest"
 	}
 
-	if maxPrice := c.Query("max_price"); maxPrice != "" {
-		if price, err := strconv.ParseFloat(maxPrice, 64); err == nil {
-			query = query.Where("price <= ?", price)
+	var sort keysetSort
+	if sortParam == "" || sortParam == "relevance" {
+		if pageReq.Cursor != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cursor pagination is not supported for relevance order"})
+			return
+		}
+		query = h.search.Rank(query, filters.Search).
+			Offset((pageReq.Page - 1) * pageReq.Limit).
+			Limit(pageReq.Limit)
+	} else {
+		var ok bool
+		if sort, ok = productSorts[sortParam]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort option"})
+			return
+		}
+		if query, err = paginate(query, sort, pageReq); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
 		}
 	}
 
-	if search := c.Query("search"); search != "" {
-		query = query.Where("name ILIKE ? OR description ILIKE ?", "%"+search+"%", "%"+search+"%")
+	if err := query.Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
 	}
 
-	// Pagination
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
+	var nextCursor string
+	if sort.Expr != "" && len(products) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.Product{}, products[len(products)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
 
-	var total int64
-	query.Model(&models.Product{}).Count(&total)
+	response := gin.H{
+		"products":   products,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	}
 
-	if err := query.Offset(offset).Limit(limit).Find(&products).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
-		return
+	if c.Query("facets") == "true" {
+		facets, err := h.productFacets(filters)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute facets"})
+			return
+		}
+		response["facets"] = facets
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"products": products,
-		"pagination": gin.H{
-			"page":  page,
-			"limit": limit,
-			"total": total,
-		},
-	})
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *ProductHandler) GetProduct(c *gin.Context) {
@@ -126,9 +309,17 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 	var product models.Product
 	if err := h.db.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id, first_name, last_name, email")
-	}).Preload("Reviews.User", func(db *gorm.DB) *gorm.DB {
+	}).Preload("Category").Preload("Reviews", "is_hidden = ?", false).Preload("Reviews.User", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id, first_name, last_name")
-	}).First(&product, productID).Error; err != nil {
+	}).Preload("Reviews.Response").Preload("Reviews.Photos", orderedReviewPhotos).Preload("Options", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Preload("Options.Values", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Preload("Images", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position ASC, id ASC")
+	}).Preload("Variants", "is_active = ?", true).
+		Preload("Variants.OptionValues").
+		First(&product, productID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -136,8 +327,16 @@ func (h *ProductHandler) GetProduct(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
 		return
 	}
+	// Hidden products are only visible to their seller
+	if userID, _ := c.Get("user_id"); product.IsHidden && userID != product.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"product": product})
+	c.JSON(http.StatusOK, gin.H{
+		"product":  product,
+		"variants": variantMatrix(product),
+	})
 }
 
 func (h *ProductHandler) UpdateProduct(c *gin.Context) {
@@ -164,8 +363,20 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.filter.Allows(req.Name, req.Description) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+	before := product
 
 	// Update fields
+	if req.SKU != "" && (product.SKU == nil || *product.SKU != req.SKU) {
+		if h.sellerSKUTaken(userID, req.SKU, product.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
+		product.SKU = &req.SKU
+	}
 	if req.Name != "" {
 		product.Name = req.Name
 	}
@@ -175,24 +386,38 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	if req.Price > 0 {
 		product.Price = req.Price
 	}
-	if req.Stock >= 0 {
-		product.Stock = req.Stock
-	}
 	if req.ImageURL != "" {
 		product.ImageURL = req.ImageURL
 	}
-	if req.Category != "" {
-		product.Category = req.Category
+	if req.CategoryID != nil {
+		var category models.Category
+		if err := h.db.First(&category, *req.CategoryID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+		product.CategoryID = req.CategoryID
 	}
 	if req.IsActive != nil {
 		product.IsActive = *req.IsActive
 	}
 
-	if err := h.db.Save(&product).Error; err != nil {
+	// Stock is only changed through the ledger
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Stock").Save(&product).Error; err != nil {
+			return err
+		}
+		if req.Stock == nil {
+			return nil
+		}
+		return services.SetStock(tx, product.ID, nil, *req.Stock, models.InventoryMovementAdjustment, req.StockReason, &userID)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
 		return
 	}
 
+	h.db.First(&product, product.ID)
+	h.alerts.ProductChanged(before, product)
+
 	c.JSON(http.StatusOK, gin.H{"product": product})
 }
 
@@ -215,10 +440,16 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&product).Error; err != nil {
+	var imageKeys []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		imageKeys, err = services.DeleteProduct(tx, product.ID)
+		return err
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
 		return
 	}
+	deleteStoredObjects(h.storage, imageKeys...)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
 }
@@ -227,10 +458,366 @@ func (h *ProductHandler) GetMyProducts(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
 	var products []models.Product
-	if err := h.db.Where("user_id = ?", userID).Preload("Reviews").Find(&products).Error; err != nil {
+	if err := h.db.Where("user_id = ?", userID).Find(&products).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"products": products})
 }
+
+func (h *ProductHandler) CreateProductOption(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var req CreateProductOptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND user_id = ?", productID, userID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	option := models.ProductOption{
+		ProductID: product.ID,
+		Name:      req.Name,
+		Position:  req.Position,
+	}
+	for i, value := range req.Values {
+		option.Values = append(option.Values, models.ProductOptionValue{Value: value, Position: i})
+	}
+
+	if err := h.db.Create(&option).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product option"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"option": option})
+}
+
+func (h *ProductHandler) DeleteProductOption(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	optionID, err := strconv.ParseUint(c.Param("option_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid option ID"})
+		return
+	}
+
+	var option models.ProductOption
+	if err := h.db.Joins("JOIN products ON products.id = product_options.product_id").
+		Where("product_options.id = ? AND products.id = ? AND products.user_id = ?", optionID, productID, userID).
+		First(&option).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Option not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch option"})
+		return
+	}
+
+	// Variants built from this option no longer describe a valid combination
+	var inUse int64
+	h.db.Table("product_variant_option_values").
+		Joins("JOIN product_option_values ON product_option_values.id = product_variant_option_values.product_option_value_id").
+		Joins("JOIN product_variants ON product_variants.id = product_variant_option_values.product_variant_id").
+		Where("product_option_values.option_id = ? AND product_variants.deleted_at IS NULL", option.ID).
+		Count(&inUse)
+	if inUse > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Option is used by existing variants"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("option_id = ?", option.ID).Delete(&models.ProductOptionValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&option).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete option"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Option deleted successfully"})
+}
+
+func (h *ProductHandler) CreateVariant(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var req CreateVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Preload("Options.Values").Preload("Variants.OptionValues").
+		Where("id = ? AND user_id = ?", productID, userID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	// A variant picks exactly one value for every option of the product
+	valueOption := make(map[uint]uint)
+	for _, option := range product.Options {
+		for _, value := range option.Values {
+			valueOption[value.ID] = option.ID
+		}
+	}
+
+	chosen := make(map[uint]bool)
+	var values []models.ProductOptionValue
+	for _, valueID := range req.OptionValueIDs {
+		optionID, ok := valueOption[valueID]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Option value does not belong to this product"})
+			return
+		}
+		if chosen[optionID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only one value per option is allowed"})
+			return
+		}
+		chosen[optionID] = true
+		values = append(values, models.ProductOptionValue{ID: valueID})
+	}
+	if len(chosen) != len(product.Options) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A value is required for every product option"})
+		return
+	}
+
+	for _, existing := range product.Variants {
+		if len(existing.OptionValues) != len(values) {
+			continue
+		}
+		same := true
+		for _, value := range existing.OptionValues {
+			if !containsValue(req.OptionValueIDs, value.ID) {
+				same = false
+				break
+			}
+		}
+		if same {
+			c.JSON(http.StatusConflict, gin.H{"error": "A variant with these options already exists"})
+			return
+		}
+	}
+
+	if taken, err := variantSKUTaken(h.db, product.ID, req.SKU, 0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check SKU"})
+		return
+	} else if taken {
+		c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+		return
+	}
+
+	variant := models.ProductVariant{
+		ProductID:    product.ID,
+		SKU:          &req.SKU,
+		Price:        req.Price,
+		ImageURL:     req.ImageURL,
+		IsActive:     true,
+		OptionValues: values,
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("OptionValues.*").Create(&variant).Error; err != nil {
+			return err
+		}
+		if req.Stock == 0 {
+			return nil
+		}
+		_, err := services.RecordStockMovement(tx, services.StockMovement{
+			ProductID: product.ID,
+			VariantID: &variant.ID,
+			Type:      models.InventoryMovementRestock,
+			Quantity:  req.Stock,
+			Reason:    "Initial stock",
+			ActorID:   &userID,
+		})
+		return err
+	}); err != nil {
+		// A concurrent request may have taken the SKU since the check
+		if taken, _ := variantSKUTaken(h.db, product.ID, req.SKU, 0); taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create variant"})
+		return
+	}
+
+	h.db.Preload("OptionValues").First(&variant, variant.ID)
+
+	c.JSON(http.StatusCreated, gin.H{"variant": variant})
+}
+
+func (h *ProductHandler) UpdateVariant(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	variantID, err := strconv.ParseUint(c.Param("variant_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return
+	}
+
+	var req UpdateVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var variant models.ProductVariant
+	if err := h.db.Joins("JOIN products ON products.id = product_variants.product_id").
+		Where("product_variants.id = ? AND products.id = ? AND products.user_id = ?", variantID, productID, userID).
+		First(&variant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variant"})
+		return
+	}
+
+	if req.SKU != "" && (variant.SKU == nil || req.SKU != *variant.SKU) {
+		if taken, err := variantSKUTaken(h.db, variant.ProductID, req.SKU, variant.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check SKU"})
+			return
+		} else if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
+		variant.SKU = &req.SKU
+	}
+	if req.Price != nil {
+		variant.Price = req.Price
+	}
+	if req.ImageURL != "" {
+		variant.ImageURL = req.ImageURL
+	}
+	if req.IsActive != nil {
+		variant.IsActive = *req.IsActive
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Stock").Save(&variant).Error; err != nil {
+			return err
+		}
+		if req.Stock == nil {
+			return nil
+		}
+		return services.SetStock(tx, variant.ProductID, &variant.ID, *req.Stock, models.InventoryMovementAdjustment, req.StockReason, &userID)
+	}); err != nil {
+		// A concurrent request may have taken the SKU since the check
+		if taken, _ := variantSKUTaken(h.db, variant.ProductID, req.SKU, variant.ID); req.SKU != "" && taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "SKU already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update variant"})
+		return
+	}
+
+	if req.Stock != nil || req.IsActive != nil {
+		h.alerts.StockChanged(variant.ProductID)
+	}
+
+	h.db.First(&variant, variant.ID)
+
+	c.JSON(http.StatusOK, gin.H{"variant": variant})
+}
+
+func (h *ProductHandler) DeleteVariant(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	variantID, err := strconv.ParseUint(c.Param("variant_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid variant ID"})
+		return
+	}
+
+	var variant models.ProductVariant
+	if err := h.db.Joins("JOIN products ON products.id = product_variants.product_id").
+		Where("product_variants.id = ? AND products.id = ? AND products.user_id = ?", variantID, productID, userID).
+		First(&variant).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variant"})
+		return
+	}
+
+	// Items for the variant would otherwise fall back to the product's price
+	// and stock, so they go with it
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.WishlistItem{}).Error; err != nil {
+			return err
+		}
+		// Release the SKU so the seller can reuse it for a new variant
+		if err := tx.Model(&variant).Update("sku", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&variant).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete variant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Variant deleted successfully"})
+}
+
+// variantSKUTaken reports whether a variant of the product other than
+// exceptID uses sku. Deleted variants release their SKU.
+func variantSKUTaken(db *gorm.DB, productID uint, sku string, exceptID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.ProductVariant{}).
+		Where("product_id = ? AND sku = ? AND id <> ?", productID, sku, exceptID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func containsValue(ids []uint, id uint) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
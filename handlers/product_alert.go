@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SubscribeProductAlertRequest struct {
+	Type models.ProductAlertType `json:"type" binding:"required,oneof=restock price_drop"`
+}
+
+// SubscribeProductAlert subscribes the user to restock or price-drop alerts
+// for a product. Subscribing again re-arms an alert that already fired.
+func (h *ProductHandler) SubscribeProductAlert(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var req SubscribeProductAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND is_active = ? AND is_hidden = ?", productID, true, false).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	if req.Type == models.ProductAlertRestock {
+		inStock, err := services.ProductInStock(h.db, product.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+			return
+		}
+		if inStock {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product is in stock"})
+			return
+		}
+	}
+
+	var alert models.ProductAlert
+	err = h.db.Where("user_id = ? AND product_id = ? AND type = ?", userID, product.ID, req.Type).First(&alert).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert"})
+		return
+	}
+	alert.UserID = userID
+	alert.ProductID = product.ID
+	alert.Type = req.Type
+	alert.Price = product.Price
+	alert.NotifiedAt = nil
+
+	if err := h.db.Save(&alert).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to alert"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"alert": alert})
+}
+
+func (h *ProductHandler) UnsubscribeProductAlert(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	result := h.db.Where("user_id = ? AND product_id = ? AND type = ?", userID, productID, c.Param("type")).
+		Delete(&models.ProductAlert{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe from alert"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed successfully"})
+}
+
+func (h *ProductHandler) GetMyProductAlerts(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var alerts []models.ProductAlert
+	if err := h.db.Preload("Product").Where("user_id = ?", userID).Order("created_at DESC").Find(&alerts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productAverageRatingSQL is the average review rating of a product, which
+// is zero for products without reviews.
+const productAverageRatingSQL = "products.average_rating"
+
+// productPopularitySQL counts the units of a product sold in orders that
+// were not cancelled.
+const productPopularitySQL = "(SELECT COALESCE(SUM(order_items.quantity), 0) FROM order_items JOIN orders ON orders.id = order_items.order_id WHERE order_items.product_id = products.id AND order_items.deleted_at IS NULL AND orders.status <> 'cancelled')"
+
+// productSorts are the orders accepted by the sort parameter of product
+// listings.
+var productSorts = map[string]keysetSort{
+	"newest":     {Expr: "products.created_at", IDColumn: "products.id", Desc: true, Time: true},
+	"price_asc":  {Expr: "products.price", IDColumn: "products.id"},
+	"price_desc": {Expr: "products.price", IDColumn: "products.id", Desc: true},
+	"rating":     {Expr: productAverageRatingSQL, IDColumn: "products.id", Desc: true},
+	"popularity": {Expr: productPopularitySQL, IDColumn: "products.id", Desc: true},
+}
+
+// productInStockSQL matches products with stock of their own or with at
+// least one active variant in stock.
+const productInStockSQL = "(products.stock > 0 OR EXISTS (SELECT 1 FROM product_variants WHERE product_variants.product_id = products.id AND product_variants.is_active = true AND product_variants.stock > 0 AND product_variants.deleted_at IS NULL))"
+
+// Facet dimensions, used to leave a dimension's own filter out when counting
+// its facet values so that selecting one category still shows the others.
+const (
+	facetCategory     = "category"
+	facetSeller       = "seller"
+	facetPrice        = "price"
+	facetRating       = "rating"
+	facetAvailability = "availability"
+)
+
+type priceBucket struct {
+	Min float64
+	Max float64 // zero means unbounded
+}
+
+var priceBuckets = []priceBucket{
+	{Min: 0, Max: 25},
+	{Min: 25, Max: 50},
+	{Min: 50, Max: 100},
+	{Min: 100, Max: 200},
+	{Min: 200, Max: 500},
+	{Min: 500},
+}
+
+// ratingBuckets are "n stars & up" thresholds.
+var ratingBuckets = []int{4, 3, 2, 1}
+
+type productFilters struct {
+	CategoryIDs []uint
+	SellerIDs   []uint
+	MinPrice    *float64
+	MaxPrice    *float64
+	MinRating   *float64
+	InStock     bool
+	Search      string
+}
+
+// splitQuery returns the comma separated values of a query parameter.
+func splitQuery(c *gin.Context, key string) []string {
+	var values []string
+	for _, raw := range c.QueryArray(key) {
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parseProductFilters reads the listing filters from the query string.
+// Categories are given by ID or slug and expand to their descendants.
+func (h *ProductHandler) parseProductFilters(c *gin.Context) (productFilters, error) {
+	var filters productFilters
+
+	for _, categoryParam := range splitQuery(c, "category") {
+		category, err := findCategory(h.db, categoryParam)
+		if err != nil {
+			return filters, err
+		}
+		categoryIDs, err := categoryDescendantIDs(h.db, category.ID)
+		if err != nil {
+			return filters, err
+		}
+		filters.CategoryIDs = append(filters.CategoryIDs, categoryIDs...)
+	}
+
+	for _, sellerParam := range splitQuery(c, "seller") {
+		if sellerID, err := strconv.ParseUint(sellerParam, 10, 32); err == nil {
+			filters.SellerIDs = append(filters.SellerIDs, uint(sellerID))
+		}
+	}
+
+	if minPrice := c.Query("min_price"); minPrice != "" {
+		if price, err := strconv.ParseFloat(minPrice, 64); err == nil {
+			filters.MinPrice = &price
+		}
+	}
+
+	if maxPrice := c.Query("max_price"); maxPrice != "" {
+		if price, err := strconv.ParseFloat(maxPrice, 64); err == nil {
+			filters.MaxPrice = &price
+		}
+	}
+
+	if minRating := c.Query("min_rating"); minRating != "" {
+		if rating, err := strconv.ParseFloat(minRating, 64); err == nil {
+			filters.MinRating = &rating
+		}
+	}
+
+	filters.InStock = c.Query("in_stock") == "true"
+	filters.Search = c.Query("search")
+
+	return filters, nil
+}
+
+// filterProducts applies filters to query, leaving out the filter of the
+// skip dimension.
+func (h *ProductHandler) filterProducts(query *gorm.DB, filters productFilters, skip string) *gorm.DB {
+	query = query.Where("products.is_active = ? AND products.is_hidden = ?", true, false)
+
+	if len(filters.CategoryIDs) > 0 && skip != facetCategory {
+		query = query.Where("products.category_id IN ?", filters.CategoryIDs)
+	}
+	if len(filters.SellerIDs) > 0 && skip != facetSeller {
+		query = query.Where("products.user_id IN ?", filters.SellerIDs)
+	}
+	if skip != facetPrice {
+		if filters.MinPrice != nil {
+			query = query.Where("products.price >= ?", *filters.MinPrice)
+		}
+		if filters.MaxPrice != nil {
+			query = query.Where("products.price <= ?", *filters.MaxPrice)
+		}
+	}
+	if filters.MinRating != nil && skip != facetRating {
+		query = query.Where(productAverageRatingSQL+" >= ?", *filters.MinRating)
+	}
+	if filters.InStock && skip != facetAvailability {
+		query = query.Where(productInStockSQL)
+	}
+	if filters.Search != "" {
+		query = h.search.Apply(query, filters.Search)
+	}
+
+	return query
+}
+
+// facetBase returns a query over the products matching filters, ignoring the
+// filter of the given dimension.
+func (h *ProductHandler) facetBase(filters productFilters, skip string) *gorm.DB {
+	matching := h.filterProducts(h.db.Model(&models.Product{}), filters, skip).Select("products.id")
+	return h.db.Model(&models.Product{}).Where("products.id IN (?)", matching)
+}
+
+// productFacets counts the products matching filters by category, price
+// bucket, average rating, seller and availability.
+func (h *ProductHandler) productFacets(filters productFilters) (gin.H, error) {
+	type categoryCount struct {
+		ID    uint   `json:"id"`
+		Name  string `json:"name"`
+		Slug  string `json:"slug"`
+		Count int64  `json:"count"`
+	}
+	var categories []categoryCount
+	if err := h.facetBase(filters, facetCategory).
+		Select("categories.id, categories.name, categories.slug, COUNT(*) AS count").
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Group("categories.id, categories.name, categories.slug").
+		Order("count DESC, categories.name ASC").
+		Scan(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	type sellerCount struct {
+		ID        uint   `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Count     int64  `json:"count"`
+	}
+	var sellers []sellerCount
+	if err := h.facetBase(filters, facetSeller).
+		Select("users.id, users.first_name, users.last_name, COUNT(*) AS count").
+		Joins("JOIN users ON users.id = products.user_id").
+		Group("users.id, users.first_name, users.last_name").
+		Order("count DESC, users.id ASC").
+		Scan(&sellers).Error; err != nil {
+		return nil, err
+	}
+
+	var priceSelects []string
+	var priceVars []interface{}
+	for _, bucket := range priceBuckets {
+		if bucket.Max > 0 {
+			priceSelects = append(priceSelects, "COALESCE(SUM(CASE WHEN products.price >= ? AND products.price < ? THEN 1 ELSE 0 END), 0)")
+			priceVars = append(priceVars, bucket.Min, bucket.Max)
+		} else {
+			priceSelects = append(priceSelects, "COALESCE(SUM(CASE WHEN products.price >= ? THEN 1 ELSE 0 END), 0)")
+			priceVars = append(priceVars, bucket.Min)
+		}
+	}
+	priceCounts := make([]int64, len(priceBuckets))
+	priceDest := make([]interface{}, len(priceCounts))
+	for i := range priceCounts {
+		priceDest[i] = &priceCounts[i]
+	}
+	if err := h.facetBase(filters, facetPrice).
+		Select(strings.Join(priceSelects, ", "), priceVars...).
+		Row().Scan(priceDest...); err != nil {
+		return nil, err
+	}
+	prices := make([]gin.H, 0, len(priceBuckets))
+	for i, bucket := range priceBuckets {
+		entry := gin.H{"min": bucket.Min, "count": priceCounts[i]}
+		if bucket.Max > 0 {
+			entry["max"] = bucket.Max
+		}
+		prices = append(prices, entry)
+	}
+
+	var ratingSelects []string
+	var ratingVars []interface{}
+	for _, threshold := range ratingBuckets {
+		ratingSelects = append(ratingSelects, "COALESCE(SUM(CASE WHEN "+productAverageRatingSQL+" >= ? THEN 1 ELSE 0 END), 0)")
+		ratingVars = append(ratingVars, threshold)
+	}
+	ratingCounts := make([]int64, len(ratingBuckets))
+	ratingDest := make([]interface{}, len(ratingCounts))
+	for i := range ratingCounts {
+		ratingDest[i] = &ratingCounts[i]
+	}
+	if err := h.facetBase(filters, facetRating).
+		Select(strings.Join(ratingSelects, ", "), ratingVars...).
+		Row().Scan(ratingDest...); err != nil {
+		return nil, err
+	}
+	ratings := make([]gin.H, 0, len(ratingBuckets))
+	for i, threshold := range ratingBuckets {
+		ratings = append(ratings, gin.H{"min_rating": threshold, "count": ratingCounts[i]})
+	}
+
+	var inStock, outOfStock int64
+	if err := h.facetBase(filters, facetAvailability).
+		Select("COALESCE(SUM(CASE WHEN "+productInStockSQL+" THEN 1 ELSE 0 END), 0), COALESCE(SUM(CASE WHEN "+productInStockSQL+" THEN 0 ELSE 1 END), 0)").
+		Row().Scan(&inStock, &outOfStock); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"categories": categories,
+		"sellers":    sellers,
+		"price":      prices,
+		"rating":     ratings,
+		"availability": gin.H{
+			"in_stock":     inStock,
+			"out_of_stock": outOfStock,
+		},
+	}, nil
+}
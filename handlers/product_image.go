@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxProductImages caps the number of images a product can have.
+const maxProductImages = 10
+
+// errTooManyImages aborts an image upload that would exceed maxProductImages.
+var errTooManyImages = errors.New("too many images")
+
+type ReorderProductImagesRequest struct {
+	ImageIDs []uint `json:"image_ids" binding:"required,min=1"`
+}
+
+// storedImage is an image written to storage along with its thumbnail.
+type storedImage struct {
+	Key          string
+	ThumbnailKey string
+	Processed    *services.ProcessedImage
+}
+
+// readUpload reads a multipart file, refusing files larger than maxBytes.
+func readUpload(fileHeader *multipart.FileHeader, maxBytes int64) ([]byte, error) {
+	if fileHeader.Size > maxBytes {
+		return nil, services.ErrImageTooLarge
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, services.ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// storeImage validates an uploaded image and writes it and its thumbnail to
+// storage below prefix.
+func storeImage(storage services.Storage, images *services.ImageProcessor, fileHeader *multipart.FileHeader, prefix string) (*storedImage, error) {
+	data, err := readUpload(fileHeader, images.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	processed, err := images.Process(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := services.NewStorageKey(prefix, processed.Extension)
+	if err != nil {
+		return nil, err
+	}
+	thumbnailKey, err := services.NewStorageKey(prefix+"/thumbnails", processed.ThumbnailExtension)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.Put(key, processed.Data, processed.ContentType); err != nil {
+		return nil, err
+	}
+	if err := storage.Put(thumbnailKey, processed.Thumbnail, processed.ThumbnailContentType); err != nil {
+		storage.Delete(key)
+		return nil, err
+	}
+
+	return &storedImage{Key: key, ThumbnailKey: thumbnailKey, Processed: processed}, nil
+}
+
+// deleteStoredObjects removes objects from storage, logging failures since
+// the database rows referencing them are already gone.
+func deleteStoredObjects(storage services.Storage, keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if err := storage.Delete(key); err != nil {
+			log.Printf("Failed to delete stored object %s: %v", key, err)
+		}
+	}
+}
+
+// uploadErrorMessage maps image validation errors to client messages.
+func uploadErrorMessage(err error) (int, string) {
+	switch err {
+	case services.ErrImageTooLarge:
+		return http.StatusRequestEntityTooLarge, "Image is too large"
+	case services.ErrUnsupportedImageType:
+		return http.StatusBadRequest, "Unsupported image type, use JPEG, PNG or GIF"
+	}
+	return http.StatusInternalServerError, "Failed to store image"
+}
+
+// syncPrimaryImage mirrors the URL of the first product image into
+// Product.ImageURL. When the product has no uploaded images left, an
+// ImageURL equal to removedURL is cleared.
+func syncPrimaryImage(db *gorm.DB, productID uint, removedURL string) error {
+	var primary models.ProductImage
+	err := db.Where("product_id = ?", productID).Order("position ASC, id ASC").First(&primary).Error
+	if err == nil {
+		return db.Model(&models.Product{}).Where("id = ?", productID).Update("image_url", primary.URL).Error
+	}
+	if err != gorm.ErrRecordNotFound {
+		return err
+	}
+	if removedURL == "" {
+		return nil
+	}
+	return db.Model(&models.Product{}).Where("id = ? AND image_url = ?", productID, removedURL).Update("image_url", "").Error
+}
+
+func (h *ProductHandler) UploadProductImages(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND user_id = ?", productID, userID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxProductImages*h.images.MaxBytes+(1<<20))
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		return
+	}
+	files := append(form.File["images"], form.File["image"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No images uploaded"})
+		return
+	}
+
+	tooManyImages := gin.H{"error": fmt.Sprintf("A product can have at most %d images", maxProductImages)}
+
+	// Reject uploads that are already over the limit before processing them
+	var existing int64
+	if err := h.db.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+		return
+	}
+	if int(existing)+len(files) > maxProductImages {
+		c.JSON(http.StatusBadRequest, tooManyImages)
+		return
+	}
+
+	var stored []*storedImage
+	removeStored := func() {
+		for _, image := range stored {
+			deleteStoredObjects(h.storage, image.Key, image.ThumbnailKey)
+		}
+	}
+
+	prefix := fmt.Sprintf("products/%d", product.ID)
+	for _, fileHeader := range files {
+		image, err := storeImage(h.storage, h.images, fileHeader, prefix)
+		if err != nil {
+			removeStored()
+			status, message := uploadErrorMessage(err)
+			c.JSON(status, gin.H{"error": message, "file": fileHeader.Filename})
+			return
+		}
+		stored = append(stored, image)
+	}
+
+	images := make([]models.ProductImage, 0, len(stored))
+	for _, image := range stored {
+		images = append(images, models.ProductImage{
+			ProductID:    product.ID,
+			URL:          h.storage.URL(image.Key),
+			ThumbnailURL: h.storage.URL(image.ThumbnailKey),
+			StorageKey:   image.Key,
+			ThumbnailKey: image.ThumbnailKey,
+			ContentType:  image.Processed.ContentType,
+			Size:         int64(len(image.Processed.Data)),
+			Width:        image.Processed.Width,
+			Height:       image.Processed.Height,
+		})
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Count again with the product locked, so that concurrent uploads
+		// cannot together exceed the limit
+		if err := services.LockRow(tx, &models.Product{}, product.ID); err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if int(existing)+len(images) > maxProductImages {
+			return errTooManyImages
+		}
+
+		var maxPosition int
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).
+			Select("COALESCE(MAX(position), -1)").Row().Scan(&maxPosition); err != nil {
+			return err
+		}
+		for i := range images {
+			images[i].Position = maxPosition + 1 + i
+		}
+		if err := tx.Create(&images).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, product.ID, "")
+	}); err != nil {
+		removeStored()
+		if err == errTooManyImages {
+			c.JSON(http.StatusBadRequest, tooManyImages)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save images"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"images": images})
+}
+
+func (h *ProductHandler) DeleteProductImage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+	imageID, err := strconv.ParseUint(c.Param("image_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		return
+	}
+
+	var image models.ProductImage
+	if err := h.db.Joins("JOIN products ON products.id = product_images.product_id").
+		Where("product_images.id = ? AND products.id = ? AND products.user_id = ?", imageID, productID, userID).
+		First(&image).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch image"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&image).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, image.ProductID, image.URL)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+		return
+	}
+
+	deleteStoredObjects(h.storage, image.StorageKey, image.ThumbnailKey)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+func (h *ProductHandler) ReorderProductImages(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	productID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var req ReorderProductImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Preload("Images").Where("id = ? AND user_id = ?", productID, userID).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	// The new order must list every image of the product exactly once
+	if len(req.ImageIDs) != len(product.Images) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every product image"})
+		return
+	}
+	for _, image := range product.Images {
+		if !containsValue(req.ImageIDs, image.ID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "image_ids must list every product image"})
+			return
+		}
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		for position, imageID := range req.ImageIDs {
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", imageID).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return syncPrimaryImage(tx, product.ID, "")
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+		return
+	}
+
+	var images []models.ProductImage
+	h.db.Where("product_id = ?", product.ID).Order("position ASC, id ASC").Find(&images)
+
+	c.JSON(http.StatusOK, gin.H{"images": images})
+}
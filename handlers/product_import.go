@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// productCSVColumns is the column layout shared by product import and export.
+var productCSVColumns = []string{"sku", "name", "description", "price", "stock", "category", "image_url", "is_active"}
+
+const (
+	maxImportFileBytes = 10 << 20
+	// Imports with more data rows than this run in the background
+	asyncImportRowThreshold = 200
+	// How often a background import reports its progress, in rows
+	importProgressInterval = 50
+)
+
+// productImport is a parsed import file.
+type productImport struct {
+	Columns map[string]int
+	Records [][]string
+}
+
+type productImportResult struct {
+	Created int
+	Updated int
+	Errors  []models.ImportRowError
+}
+
+// value returns the trimmed value of column in record and whether the
+// column is present in the file.
+func (p *productImport) value(record []string, column string) (string, bool) {
+	index, ok := p.Columns[column]
+	if !ok || index >= len(record) {
+		return "", false
+	}
+	return strings.TrimSpace(record[index]), true
+}
+
+// parseProductImport reads a CSV file whose first line names the columns.
+// Columns may appear in any order; name and price are required.
+func parseProductImport(data []byte) (*productImport, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV header: %v", err)
+	}
+
+	known := make(map[string]bool)
+	for _, column := range productCSVColumns {
+		known[column] = true
+	}
+
+	columns := make(map[string]int)
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !known[column] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		if _, dup := columns[column]; dup {
+			return nil, fmt.Errorf("duplicate column %q", column)
+		}
+		columns[column] = i
+	}
+	for _, required := range []string{"name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing required column %q", required)
+		}
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+
+	return &productImport{Columns: columns, Records: records}, nil
+}
+
+// runProductImport validates every record and, unless dryRun is set, creates
+// products or updates the seller's product with the same SKU. Invalid rows
+// are reported and skipped. progress is called with the number of processed
+// rows every importProgressInterval rows.
+func (h *ProductHandler) runProductImport(userID uint, file *productImport, dryRun bool, progress func(processed int, result productImportResult)) productImportResult {
+	var result productImportResult
+
+	var categories []models.Category
+	h.db.Select("id, slug").Find(&categories)
+	categoryIDs := make(map[string]uint)
+	for _, category := range categories {
+		categoryIDs[category.Slug] = category.ID
+	}
+
+	seenSKUs := make(map[string]int)
+	for i, record := range file.Records {
+		row := i + 2
+		if progress != nil && i > 0 && i%importProgressInterval == 0 {
+			progress(i, result)
+		}
+
+		rowError := func(column, message string) {
+			result.Errors = append(result.Errors, models.ImportRowError{Row: row, Column: column, Error: message})
+		}
+
+		if len(record) != len(file.Columns) {
+			rowError("", fmt.Sprintf("expected %d fields, got %d", len(file.Columns), len(record)))
+			continue
+		}
+
+		sku, _ := file.value(record, "sku")
+		if sku != "" {
+			if firstRow, dup := seenSKUs[sku]; dup {
+				rowError("sku", fmt.Sprintf("duplicate SKU, already used on row %d", firstRow))
+				continue
+			}
+			seenSKUs[sku] = row
+		}
+
+		// Look the existing product up first, missing columns keep its values
+		var product, before models.Product
+		existing := false
+		if sku != "" {
+			if err := h.db.Where("user_id = ? AND sku = ?", userID, sku).First(&product).Error; err == nil {
+				existing = true
+				before = product
+			} else if err != gorm.ErrRecordNotFound {
+				rowError("sku", "failed to look up SKU")
+				continue
+			}
+		}
+		if !existing {
+			product = models.Product{UserID: userID, IsActive: true}
+			if sku != "" {
+				product.SKU = &sku
+			}
+		}
+
+		valid := true
+		var stock *int
+		if name, ok := file.value(record, "name"); ok {
+			if name == "" {
+				rowError("name", "name is required")
+				valid = false
+			}
+			product.Name = name
+		}
+		if description, ok := file.value(record, "description"); ok {
+			product.Description = description
+		}
+		if priceValue, ok := file.value(record, "price"); ok {
+			price, err := strconv.ParseFloat(priceValue, 64)
+			if err != nil || price <= 0 {
+				rowError("price", "price must be a number greater than 0")
+				valid = false
+			}
+			product.Price = price
+		}
+		if stockValue, ok := file.value(record, "stock"); ok && stockValue != "" {
+			value, err := strconv.Atoi(stockValue)
+			if err != nil || value < 0 {
+				rowError("stock", "stock must be a whole number of at least 0")
+				valid = false
+			}
+			stock = &value
+		}
+		if categoryValue, ok := file.value(record, "category"); ok {
+			if categoryValue == "" {
+				product.CategoryID = nil
+			} else if categoryID, found := categoryIDs[models.Slugify(categoryValue)]; found {
+				product.CategoryID = &categoryID
+			} else {
+				rowError("category", fmt.Sprintf("unknown category %q", categoryValue))
+				valid = false
+			}
+		}
+		if imageURL, ok := file.value(record, "image_url"); ok {
+			product.ImageURL = imageURL
+		}
+		if activeValue, ok := file.value(record, "is_active"); ok && activeValue != "" {
+			active, err := strconv.ParseBool(activeValue)
+			if err != nil {
+				rowError("is_active", "is_active must be true or false")
+				valid = false
+			}
+			product.IsActive = active
+		}
+		if !h.filter.Allows(product.Name, product.Description) {
+			rowError("", "name or description contains words that are not allowed")
+			valid = false
+		}
+
+		if !valid {
+			continue
+		}
+
+		if !dryRun {
+			// Stock changes are recorded in the inventory ledger
+			err := h.db.Transaction(func(tx *gorm.DB) error {
+				if existing {
+					if err := tx.Omit("Stock").Save(&product).Error; err != nil {
+						return err
+					}
+				} else {
+					// Create replaces the false zero value with the column's
+					// default, so drafts are deactivated afterwards
+					active := product.IsActive
+					if err := tx.Create(&product).Error; err != nil {
+						return err
+					}
+					if !active {
+						if err := tx.Model(&product).Update("is_active", false).Error; err != nil {
+							return err
+						}
+					}
+				}
+				if stock == nil {
+					return nil
+				}
+				movementType := models.InventoryMovementAdjustment
+				if !existing {
+					movementType = models.InventoryMovementRestock
+				}
+				return services.SetStock(tx, product.ID, nil, *stock, movementType, "CSV import", &userID)
+			})
+			if err != nil {
+				rowError("", "failed to save product")
+				continue
+			}
+
+			var updated models.Product
+			if existing && h.db.First(&updated, product.ID).Error == nil {
+				h.alerts.ProductChanged(before, updated)
+			}
+		}
+
+		if existing {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+
+	return result
+}
+
+// readImportFile returns the uploaded CSV, sent either as a multipart "file"
+// field or as the raw request body.
+func readImportFile(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileBytes+(1<<20))
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("file is required")
+		}
+		if fileHeader.Size > maxImportFileBytes {
+			return nil, fmt.Errorf("file is too large")
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, fmt.Errorf("file is too large")
+	}
+	if len(data) > maxImportFileBytes {
+		return nil, fmt.Errorf("file is too large")
+	}
+	return data, nil
+}
+
+func (h *ProductHandler) ImportProducts(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	dryRun := c.Query("dry_run") == "true"
+
+	data, err := readImportFile(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := parseProductImport(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(file.Records) <= asyncImportRowThreshold {
+		result := h.runProductImport(userID, file, dryRun, nil)
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":    dryRun,
+			"total_rows": len(file.Records),
+			"created":    result.Created,
+			"updated":    result.Updated,
+			"errors":     result.Errors,
+		})
+		return
+	}
+
+	job := models.ImportJob{
+		UserID:    userID,
+		Status:    models.ImportJobStatusPending,
+		DryRun:    dryRun,
+		TotalRows: len(file.Records),
+	}
+	if err := h.db.Create(&job).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import job"})
+		return
+	}
+
+	go h.processImportJob(job, file)
+
+	c.JSON(http.StatusAccepted, gin.H{"job": job})
+}
+
+// processImportJob runs a large import in the background, recording its
+// progress and outcome on the job.
+func (h *ProductHandler) processImportJob(job models.ImportJob, file *productImport) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Product import job %d failed: %v", job.ID, r)
+			now := time.Now()
+			h.db.Model(&job).Updates(map[string]interface{}{
+				"status":         models.ImportJobStatusFailed,
+				"failure_reason": "internal error",
+				"completed_at":   &now,
+			})
+		}
+	}()
+
+	h.db.Model(&job).Update("status", models.ImportJobStatusRunning)
+
+	result := h.runProductImport(job.UserID, file, job.DryRun, func(processed int, partial productImportResult) {
+		h.db.Model(&job).Updates(map[string]interface{}{
+			"processed_rows": processed,
+			"created":        partial.Created,
+			"updated":        partial.Updated,
+		})
+	})
+
+	now := time.Now()
+	job.Status = models.ImportJobStatusCompleted
+	job.ProcessedRows = len(file.Records)
+	job.Created = result.Created
+	job.Updated = result.Updated
+	job.RowErrors = result.Errors
+	job.CompletedAt = &now
+	if err := h.db.Save(&job).Error; err != nil {
+		log.Printf("Failed to save product import job %d: %v", job.ID, err)
+	}
+}
+
+func (h *ProductHandler) GetImportJob(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	jobID, err := strconv.ParseUint(c.Param("job_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	var job models.ImportJob
+	if err := h.db.Where("id = ? AND user_id = ?", jobID, userID).First(&job).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch import job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+func (h *ProductHandler) ExportProducts(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var products []models.Product
+	if err := h.db.Where("user_id = ?", userID).Preload("Category").Order("id ASC").Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(productCSVColumns)
+	for _, product := range products {
+		sku := ""
+		if product.SKU != nil {
+			sku = *product.SKU
+		}
+		category := ""
+		if product.Category != nil {
+			category = product.Category.Slug
+		}
+		writer.Write([]string{
+			sku,
+			product.Name,
+			product.Description,
+			strconv.FormatFloat(product.Price, 'f', -1, 64),
+			strconv.Itoa(product.Stock),
+			category,
+			product.ImageURL,
+			strconv.FormatBool(product.IsActive),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export products"})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="products.csv"`)
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
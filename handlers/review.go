@@ -2,20 +2,68 @@ package handlers
 
 import (
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"ecommerce-app/models"
+	"ecommerce-app/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type ReviewHandler struct {
-	db *gorm.DB
+	db               *gorm.DB
+	emailService     services.EmailServiceInterface
+	websocketService *services.WebSocketService
+	requirePurchase  bool
+	filter           *services.ContentFilter
+	storage          services.Storage
+	images           *services.ImageProcessor
+	maxPhotos        int
 }
 
-func NewReviewHandler(db *gorm.DB) *ReviewHandler {
-	return &ReviewHandler{db: db}
+// NewReviewHandler returns a ReviewHandler. Reviews are limited to buyers
+// with a delivered order of the product unless REVIEWS_REQUIRE_PURCHASE is
+// set to false, and carry up to REVIEW_MAX_PHOTOS photos (default 5).
+// websocketService may be nil to notify by email only.
+func NewReviewHandler(db *gorm.DB, emailService services.EmailServiceInterface, websocketService *services.WebSocketService, storage services.Storage, images *services.ImageProcessor) *ReviewHandler {
+	requirePurchase, err := strconv.ParseBool(os.Getenv("REVIEWS_REQUIRE_PURCHASE"))
+	if err != nil {
+		requirePurchase = true
+	}
+	maxPhotos, err := strconv.Atoi(os.Getenv("REVIEW_MAX_PHOTOS"))
+	if err != nil || maxPhotos < 0 {
+		maxPhotos = 5
+	}
+	return &ReviewHandler{
+		db:               db,
+		emailService:     emailService,
+		websocketService: websocketService,
+		requirePurchase:  requirePurchase,
+		filter:           services.ContentFilterFromEnv(),
+		storage:          storage,
+		images:           images,
+		maxPhotos:        maxPhotos,
+	}
+}
+
+// reviewSorts are the orders accepted by the sort parameter of review
+// listings.
+var reviewSorts = map[string]keysetSort{
+	"newest":  createdAtSort("reviews", true),
+	"helpful": {Expr: "reviews.helpful_count", IDColumn: "reviews.id", Desc: true},
+	"highest": {Expr: "reviews.rating", IDColumn: "reviews.id", Desc: true},
+	"lowest":  {Expr: "reviews.rating", IDColumn: "reviews.id"},
+}
+
+// deliveredPurchase restricts a query on order items to delivered orders of
+// the user.
+func deliveredPurchase(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL").
+		Where("orders.user_id = ? AND orders.status = ?", userID, models.OrderStatusDelivered)
 }
 
 type CreateReviewRequest struct {
@@ -24,6 +72,10 @@ type CreateReviewRequest struct {
 	Comment   string `json:"comment"`
 }
 
+type VoteReviewRequest struct {
+	Helpful *bool `json:"helpful" binding:"required"`
+}
+
 type UpdateReviewRequest struct {
 	Rating  int    `json:"rating" binding:"required,min=1,max=5"`
 	Comment string `json:"comment"`
@@ -38,9 +90,14 @@ func (h *ReviewHandler) CreateReview(c *gin.Context) {
 		return
 	}
 
+	if !h.filter.Allows(req.Comment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+
 	// Check if product exists
 	var product models.Product
-	if err := h.db.Where("id = ? AND is_active = ?", req.ProductID, true).First(&product).Error; err != nil {
+	if err := h.db.Where("id = ? AND is_active = ? AND is_hidden = ?", req.ProductID, true, false).First(&product).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 			return
@@ -49,6 +106,21 @@ func (h *ReviewHandler) CreateReview(c *gin.Context) {
 		return
 	}
 
+	if product.UserID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot review your own product"})
+		return
+	}
+
+	var purchases int64
+	if err := deliveredPurchase(h.db, userID).Where("order_items.product_id = ?", product.ID).Count(&purchases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check purchases"})
+		return
+	}
+	if h.requirePurchase && purchases == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only buyers who received this product can review it"})
+		return
+	}
+
 	// Check if user already reviewed this product
 	var existingReview models.Review
 	if err := h.db.Where("user_id = ? AND product_id = ?", userID, req.ProductID).First(&existingReview).Error; err == nil {
@@ -58,13 +130,20 @@ func (h *ReviewHandler) CreateReview(c *gin.Context) {
 
 	// Create review
 	review := models.Review{
-		UserID:    userID,
-		ProductID: req.ProductID,
-		Rating:    req.Rating,
-		Comment:   req.Comment,
+		UserID:           userID,
+		ProductID:        req.ProductID,
+		Rating:           req.Rating,
+		Comment:          req.Comment,
+		VerifiedPurchase: purchases > 0,
 	}
 
-	if err := h.db.Create(&review).Error; err != nil {
+	// The product's rating aggregates change with the review
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+		return services.AdjustProductRating(tx, review.ProductID, review.Rating, 1)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create review"})
 		return
 	}
@@ -80,15 +159,79 @@ func (h *ReviewHandler) GetProductReviews(c *gin.Context) {
 		return
 	}
 
-	var reviews []models.Review
-	if err := h.db.Preload("User", func(db *gorm.DB) *gorm.DB {
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	sort, ok := reviewSorts[c.DefaultQuery("sort", "newest")]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort option"})
+		return
+	}
+
+	// Star ratings to list, e.g. rating=4,5
+	var ratings []int
+	if raw := c.Query("rating"); raw != "" {
+		for _, value := range strings.Split(raw, ",") {
+			rating, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || rating < 1 || rating > 5 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rating filter"})
+				return
+			}
+			ratings = append(ratings, rating)
+		}
+	}
+
+	// Pass verified=true to only list reviews of verified purchases and
+	// with_photos=true to only list reviews with photos
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("product_id = ? AND is_hidden = ?", id, false)
+		if verified, _ := strconv.ParseBool(c.Query("verified")); verified {
+			db = db.Where("verified_purchase = ?", true)
+		}
+		if withPhotos, _ := strconv.ParseBool(c.Query("with_photos")); withPhotos {
+			db = db.Where("EXISTS (SELECT 1 FROM review_photos WHERE review_photos.review_id = reviews.id)")
+		}
+		if len(ratings) > 0 {
+			db = db.Where("rating IN ?", ratings)
+		}
+		return db
+	}
+
+	var total int64
+	if err := h.db.Model(&models.Review{}).Scopes(filter).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count reviews"})
+		return
+	}
+
+	query, err := paginate(h.db.Preload("User", func(db *gorm.DB) *gorm.DB {
 		return db.Select("id, first_name, last_name")
-	}).Where("product_id = ?", id).Order("created_at DESC").Find(&reviews).Error; err != nil {
+	}).Preload("Response").Preload("Photos", orderedReviewPhotos).Scopes(filter), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var reviews []models.Review
+	if err := query.Find(&reviews).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+	var nextCursor string
+	if len(reviews) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.Review{}, reviews[len(reviews)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews":    reviews,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
 }
 
 func (h *ReviewHandler) UpdateReview(c *gin.Context) {
@@ -105,6 +248,10 @@ func (h *ReviewHandler) UpdateReview(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !h.filter.Allows(req.Comment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
 
 	var review models.Review
 	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&review).Error; err != nil {
@@ -117,10 +264,23 @@ func (h *ReviewHandler) UpdateReview(c *gin.Context) {
 	}
 
 	// Update review
+	previousRating := review.Rating
 	review.Rating = req.Rating
 	review.Comment = req.Comment
 
-	if err := h.db.Save(&review).Error; err != nil {
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&review).Error; err != nil {
+			return err
+		}
+		// Hidden reviews are not part of the aggregates
+		if review.Rating == previousRating || review.IsHidden {
+			return nil
+		}
+		if err := services.AdjustProductRating(tx, review.ProductID, previousRating, -1); err != nil {
+			return err
+		}
+		return services.AdjustProductRating(tx, review.ProductID, review.Rating, 1)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update review"})
 		return
 	}
@@ -137,10 +297,35 @@ func (h *ReviewHandler) DeleteReview(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Review{}).Error; err != nil {
+	var review models.Review
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&review).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review"})
+		return
+	}
+
+	var photoKeys []string
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+		keys, err := deleteReviewPhotos(tx, review.ID)
+		if err != nil {
+			return err
+		}
+		photoKeys = keys
+		if review.IsHidden {
+			return nil
+		}
+		return services.AdjustProductRating(tx, review.ProductID, review.Rating, -1)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete review"})
 		return
 	}
+	deleteStoredObjects(h.storage, photoKeys...)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Review deleted successfully"})
 }
@@ -148,11 +333,172 @@ func (h *ReviewHandler) DeleteReview(c *gin.Context) {
 func (h *ReviewHandler) GetMyReviews(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
+	pageReq, err := parsePageRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
+	var total int64
+	if err := h.db.Model(&models.Review{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count reviews"})
+		return
+	}
+
+	sort := createdAtSort("reviews", true)
+	query, err := paginate(h.db.Preload("Product").Preload("Photos", orderedReviewPhotos).Where("user_id = ?", userID), sort, pageReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+
 	var reviews []models.Review
-	if err := h.db.Preload("Product").Where("user_id = ?", userID).Order("created_at DESC").Find(&reviews).Error; err != nil {
+	if err := query.Find(&reviews).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+	var nextCursor string
+	if len(reviews) == pageReq.Limit {
+		if nextCursor, err = sort.NextCursor(h.db, &models.Review{}, reviews[len(reviews)-1].ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build cursor"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews":    reviews,
+		"pagination": paginationResponse(pageReq, total, nextCursor),
+	})
+}
+
+// GetProductReviewSummary returns the rating aggregates of a product: the
+// average rating, the number of reviews and the number per star rating.
+func (h *ReviewHandler) GetProductReviewSummary(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.First(&product, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"product_id":     product.ID,
+		"average_rating": product.AverageRating,
+		"review_count":   product.ReviewCount,
+		"histogram":      product.RatingHistogram(),
+	})
+}
+
+// voteCountColumn is the Review counter a vote adds to.
+func voteCountColumn(helpful bool) string {
+	if helpful {
+		return "helpful_count"
+	}
+	return "unhelpful_count"
+}
+
+// VoteReview records the user's helpful or unhelpful vote on a review,
+// replacing an earlier vote of the user.
+func (h *ReviewHandler) VoteReview(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return
+	}
+
+	var req VoteReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var review models.Review
+	if err := h.db.Where("is_hidden = ?", false).First(&review, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review"})
+		return
+	}
+	if review.UserID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot vote on your own review"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var vote models.ReviewVote
+		err := tx.Where("review_id = ? AND user_id = ?", review.ID, userID).First(&vote).Error
+		switch {
+		case err == gorm.ErrRecordNotFound:
+			vote = models.ReviewVote{ReviewID: review.ID, UserID: userID, Helpful: *req.Helpful}
+			if err := tx.Create(&vote).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case vote.Helpful == *req.Helpful:
+			return nil
+		default:
+			if err := tx.Model(&vote).Update("helpful", *req.Helpful).Error; err != nil {
+				return err
+			}
+			previous := voteCountColumn(!*req.Helpful)
+			if err := tx.Model(&review).UpdateColumn(previous, gorm.Expr(previous+" - 1")).Error; err != nil {
+				return err
+			}
+		}
+		column := voteCountColumn(*req.Helpful)
+		return tx.Model(&review).UpdateColumn(column, gorm.Expr(column+" + 1")).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record vote"})
+		return
+	}
+
+	h.db.First(&review, review.ID)
+	c.JSON(http.StatusOK, gin.H{"review": review})
+}
+
+// DeleteReviewVote withdraws the user's vote on a review.
+func (h *ReviewHandler) DeleteReviewVote(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return
+	}
+
+	var vote models.ReviewVote
+	if err := h.db.Where("review_id = ? AND user_id = ?", id, userID).First(&vote).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vote not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vote"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&vote).Error; err != nil {
+			return err
+		}
+		column := voteCountColumn(vote.Helpful)
+		return tx.Model(&models.Review{}).Where("id = ?", vote.ReviewID).UpdateColumn(column, gorm.Expr(column+" - 1")).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete vote"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vote deleted successfully"})
 }
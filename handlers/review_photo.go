@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// orderedReviewPhotos preloads review photos in their upload order.
+func orderedReviewPhotos(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC, id ASC")
+}
+
+// deleteReviewPhotos removes the photos of a review from the database and
+// returns their storage keys, to be deleted once the transaction commits.
+func deleteReviewPhotos(tx *gorm.DB, reviewID uint) ([]string, error) {
+	var photos []models.ReviewPhoto
+	if err := tx.Where("review_id = ?", reviewID).Find(&photos).Error; err != nil {
+		return nil, err
+	}
+	if len(photos) == 0 {
+		return nil, nil
+	}
+	if err := tx.Where("review_id = ?", reviewID).Delete(&models.ReviewPhoto{}).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, 2*len(photos))
+	for _, photo := range photos {
+		keys = append(keys, photo.StorageKey, photo.ThumbnailKey)
+	}
+	return keys, nil
+}
+
+// errTooManyPhotos aborts a photo upload that would exceed the limit.
+var errTooManyPhotos = errors.New("too many photos")
+
+// UploadReviewPhotos attaches the images of the photos form field to a
+// review of the user.
+func (h *ReviewHandler) UploadReviewPhotos(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	reviewID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return
+	}
+
+	var review models.Review
+	if err := h.db.Where("id = ? AND user_id = ?", reviewID, userID).First(&review).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.maxPhotos)*h.images.MaxBytes+(1<<20))
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		return
+	}
+	files := append(form.File["photos"], form.File["photo"]...)
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No photos uploaded"})
+		return
+	}
+
+	tooManyPhotos := gin.H{"error": fmt.Sprintf("A review can have at most %d photos", h.maxPhotos)}
+
+	// Reject uploads that are already over the limit before processing them
+	var existing int64
+	if err := h.db.Model(&models.ReviewPhoto{}).Where("review_id = ?", review.ID).Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch photos"})
+		return
+	}
+	if int(existing)+len(files) > h.maxPhotos {
+		c.JSON(http.StatusBadRequest, tooManyPhotos)
+		return
+	}
+
+	var stored []*storedImage
+	removeStored := func() {
+		for _, image := range stored {
+			deleteStoredObjects(h.storage, image.Key, image.ThumbnailKey)
+		}
+	}
+
+	prefix := fmt.Sprintf("reviews/%d", review.ID)
+	for _, fileHeader := range files {
+		image, err := storeImage(h.storage, h.images, fileHeader, prefix)
+		if err != nil {
+			removeStored()
+			status, message := uploadErrorMessage(err)
+			c.JSON(status, gin.H{"error": message, "file": fileHeader.Filename})
+			return
+		}
+		stored = append(stored, image)
+	}
+
+	photos := make([]models.ReviewPhoto, 0, len(stored))
+	for _, image := range stored {
+		photos = append(photos, models.ReviewPhoto{
+			ReviewID:     review.ID,
+			URL:          h.storage.URL(image.Key),
+			ThumbnailURL: h.storage.URL(image.ThumbnailKey),
+			StorageKey:   image.Key,
+			ThumbnailKey: image.ThumbnailKey,
+			ContentType:  image.Processed.ContentType,
+			Size:         int64(len(image.Processed.Data)),
+			Width:        image.Processed.Width,
+			Height:       image.Processed.Height,
+		})
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Count again with the review locked, so that concurrent uploads
+		// cannot together exceed the limit
+		if err := services.LockRow(tx, &models.Review{}, review.ID); err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&models.ReviewPhoto{}).Where("review_id = ?", review.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if int(existing)+len(photos) > h.maxPhotos {
+			return errTooManyPhotos
+		}
+
+		var maxPosition int
+		if err := tx.Model(&models.ReviewPhoto{}).Where("review_id = ?", review.ID).
+			Select("COALESCE(MAX(position), -1)").Row().Scan(&maxPosition); err != nil {
+			return err
+		}
+		for i := range photos {
+			photos[i].Position = maxPosition + 1 + i
+		}
+		return tx.Create(&photos).Error
+	}); err != nil {
+		removeStored()
+		if err == errTooManyPhotos {
+			c.JSON(http.StatusBadRequest, tooManyPhotos)
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save photos"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"photos": photos})
+}
+
+func (h *ReviewHandler) DeleteReviewPhoto(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	reviewID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return
+	}
+	photoID, err := strconv.ParseUint(c.Param("photo_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid photo ID"})
+		return
+	}
+
+	var photo models.ReviewPhoto
+	if err := h.db.Joins("JOIN reviews ON reviews.id = review_photos.review_id AND reviews.deleted_at IS NULL").
+		Where("review_photos.id = ? AND reviews.id = ? AND reviews.user_id = ?", photoID, reviewID, userID).
+		First(&photo).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Photo not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch photo"})
+		return
+	}
+
+	if err := h.db.Delete(&photo).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete photo"})
+		return
+	}
+	deleteStoredObjects(h.storage, photo.StorageKey, photo.ThumbnailKey)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Photo deleted successfully"})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ReviewResponseRequest struct {
+	Body string `json:"body" binding:"required,max=2000"`
+}
+
+// findSellerReview loads the review from the :id parameter, together with
+// its product and response, when the product belongs to the user. It writes
+// the error response if it cannot.
+func (h *ReviewHandler) findSellerReview(c *gin.Context, userID uint) (*models.Review, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid review ID"})
+		return nil, false
+	}
+
+	var review models.Review
+	if err := h.db.Preload("Product").Preload("User").Preload("Response").First(&review, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch review"})
+		return nil, false
+	}
+	if review.Product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the seller of the product can respond to its reviews"})
+		return nil, false
+	}
+	return &review, true
+}
+
+// RespondToReview posts or edits the seller's public response to a review
+// of their product. The reviewer is notified when the response is posted.
+func (h *ReviewHandler) RespondToReview(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req ReviewResponseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !h.filter.Allows(req.Body) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errContentFiltered})
+		return
+	}
+
+	review, ok := h.findSellerReview(c, userID)
+	if !ok {
+		return
+	}
+
+	if review.Response != nil {
+		review.Response.Body = req.Body
+		if err := h.db.Save(review.Response).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update response"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"response": review.Response})
+		return
+	}
+
+	response := models.ReviewResponse{ReviewID: review.ID, UserID: userID, Body: req.Body}
+	if err := h.db.Create(&response).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create response"})
+		return
+	}
+
+	// Tell the reviewer about the response
+	if err := h.emailService.SendReviewResponse(review.User.Email, review.Product.Name, review.ProductID, response.Body); err != nil {
+		log.Printf("Failed to email review response %d: %v", response.ID, err)
+	}
+	if h.websocketService != nil {
+		h.websocketService.SendNotification(review.UserID, "review_response", gin.H{
+			"review_id":   review.ID,
+			"product_id":  review.ProductID,
+			"response_id": response.ID,
+			"body":        response.Body,
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"response": response})
+}
+
+func (h *ReviewHandler) DeleteReviewResponse(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	review, ok := h.findSellerReview(c, userID)
+	if !ok {
+		return
+	}
+	if review.Response == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Response not found"})
+		return
+	}
+
+	if err := h.db.Delete(review.Response).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete response"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Response deleted successfully"})
+}
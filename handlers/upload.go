@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// publicUploadPrefixes are the storage key prefixes that may be downloaded
+// without authentication.
+var publicUploadPrefixes = []string{"products/", "reviews/"}
+
+type UploadHandler struct {
+	storage services.Storage
+}
+
+func NewUploadHandler(storage services.Storage) *UploadHandler {
+	return &UploadHandler{storage: storage}
+}
+
+func (h *UploadHandler) ServeUpload(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	public := false
+	for _, prefix := range publicUploadPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			public = true
+			break
+		}
+	}
+	if !public || strings.Contains(key, "..") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	reader, contentType, err := h.storage.Get(key)
+	if err != nil {
+		if err == services.ErrObjectNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer reader.Close()
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+	io.Copy(c.Writer, reader)
+}
@@ -0,0 +1,570 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"ecommerce-app/models"
+	"ecommerce-app/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// saveForLaterName is the name of the list created for items saved for later.
+const saveForLaterName = "Saved for later"
+
+type WishlistHandler struct {
+	db *gorm.DB
+}
+
+func NewWishlistHandler(db *gorm.DB) *WishlistHandler {
+	return &WishlistHandler{db: db}
+}
+
+type CreateWishlistRequest struct {
+	Name     string `json:"name" binding:"required"`
+	IsPublic bool   `json:"is_public"`
+}
+
+type UpdateWishlistRequest struct {
+	Name     *string `json:"name"`
+	IsPublic *bool   `json:"is_public"`
+}
+
+type AddWishlistItemRequest struct {
+	ProductID uint  `json:"product_id" binding:"required"`
+	VariantID *uint `json:"variant_id"`
+	Quantity  int   `json:"quantity" binding:"omitempty,gt=0"`
+}
+
+type MoveToWishlistRequest struct {
+	WishlistID uint `json:"wishlist_id" binding:"required"`
+}
+
+// wishlistItemView is a wishlist item with the current price and stock of
+// its product, so that discounts and restocks stand out.
+type wishlistItemView struct {
+	models.WishlistItem
+	CurrentPrice float64 `json:"current_price"`
+	PriceDropped bool    `json:"price_dropped"`
+	Available    int     `json:"available"`
+	InStock      bool    `json:"in_stock"`
+	IsActive     bool    `json:"is_active"`
+}
+
+// wishlistView is a wishlist with item views in place of its items.
+type wishlistView struct {
+	models.Wishlist
+	Items []wishlistItemView `json:"items"`
+}
+
+// preloadWishlistItems preloads the items of wishlists including deleted
+// products and variants, which are shown as no longer available.
+func preloadWishlistItems(db *gorm.DB) *gorm.DB {
+	unscoped := func(db *gorm.DB) *gorm.DB { return db.Unscoped() }
+	return db.Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("created_at DESC") }).
+		Preload("Items.Product", unscoped).
+		Preload("Items.Variant", unscoped).
+		Preload("Items.Variant.OptionValues")
+}
+
+// newWishlistView adds the current price and stock to the items of a
+// wishlist loaded with preloadWishlistItems.
+func newWishlistView(db *gorm.DB, wishlist models.Wishlist) (wishlistView, error) {
+	view := wishlistView{Wishlist: wishlist, Items: []wishlistItemView{}}
+	for _, item := range wishlist.Items {
+		itemView := wishlistItemView{WishlistItem: item}
+		itemView.IsActive = item.Product.ID != 0 && !item.Product.DeletedAt.Valid && item.Product.IsAvailable() &&
+			(item.Variant == nil || (!item.Variant.DeletedAt.Valid && item.Variant.IsActive))
+
+		itemView.CurrentPrice = item.Product.Price
+		if item.Variant != nil {
+			itemView.CurrentPrice = item.Variant.EffectivePrice(item.Product)
+		}
+		itemView.PriceDropped = item.PriceAtAdd != 0 && itemView.CurrentPrice < item.PriceAtAdd
+
+		if itemView.IsActive {
+			available, err := services.AvailableStock(db, item.ProductID, item.VariantID, 0)
+			if err != nil {
+				return view, err
+			}
+			itemView.Available = max(available, 0)
+			itemView.InStock = available >= item.Quantity
+		}
+		view.Items = append(view.Items, itemView)
+	}
+	return view, nil
+}
+
+// newShareToken returns a random token for the public link of a wishlist.
+func newShareToken() (*string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(bytes)
+	return &token, nil
+}
+
+// saveForLaterList returns the save-for-later list of a user, creating it on
+// first use.
+func saveForLaterList(db *gorm.DB, userID uint) (*models.Wishlist, error) {
+	var wishlist models.Wishlist
+	err := db.Where("user_id = ? AND save_for_later = ?", userID, true).First(&wishlist).Error
+	if err == gorm.ErrRecordNotFound {
+		wishlist = models.Wishlist{UserID: userID, Name: saveForLaterName, SaveForLater: true}
+		err = db.Create(&wishlist).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &wishlist, nil
+}
+
+// addWishlistItem adds quantity of a product, or of one of its variants, to a
+// wishlist, merging it with an existing item for the same product and variant.
+func addWishlistItem(db *gorm.DB, wishlistID uint, productID uint, variantID *uint, quantity int, price float64) (*models.WishlistItem, error) {
+	var item models.WishlistItem
+	itemQuery := db.Where("wishlist_id = ? AND product_id = ?", wishlistID, productID)
+	if variantID != nil {
+		itemQuery = itemQuery.Where("variant_id = ?", *variantID)
+	} else {
+		itemQuery = itemQuery.Where("variant_id IS NULL")
+	}
+	if err := itemQuery.First(&item).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		item = models.WishlistItem{
+			WishlistID: wishlistID,
+			ProductID:  productID,
+			VariantID:  variantID,
+			Quantity:   quantity,
+			PriceAtAdd: price,
+		}
+		return &item, db.Create(&item).Error
+	}
+
+	// Keep the earlier price so that a drop since then is still reported
+	item.Quantity += quantity
+	return &item, db.Save(&item).Error
+}
+
+// findWishlist loads a wishlist of the caller from the :id parameter and
+// writes the error response if it cannot.
+func (h *WishlistHandler) findWishlist(c *gin.Context, query *gorm.DB) (*models.Wishlist, bool) {
+	userID := c.MustGet("user_id").(uint)
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wishlist ID"})
+		return nil, false
+	}
+
+	var wishlist models.Wishlist
+	if err := query.Where("id = ? AND user_id = ?", id, userID).First(&wishlist).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+		return nil, false
+	}
+	return &wishlist, true
+}
+
+func (h *WishlistHandler) GetWishlists(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var wishlists []models.Wishlist
+	if err := preloadWishlistItems(h.db).Where("user_id = ?", userID).
+		Order("save_for_later DESC, created_at ASC").Find(&wishlists).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlists"})
+		return
+	}
+
+	views := []wishlistView{}
+	for _, wishlist := range wishlists {
+		view, err := newWishlistView(h.db, wishlist)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+			return
+		}
+		views = append(views, view)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wishlists": views})
+}
+
+func (h *WishlistHandler) CreateWishlist(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req CreateWishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	wishlist := models.Wishlist{UserID: userID, Name: req.Name, IsPublic: req.IsPublic}
+	if req.IsPublic {
+		token, err := newShareToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create wishlist"})
+			return
+		}
+		wishlist.ShareToken = token
+	}
+
+	if err := h.db.Create(&wishlist).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"wishlist": wishlist})
+}
+
+func (h *WishlistHandler) GetWishlist(c *gin.Context) {
+	wishlist, ok := h.findWishlist(c, preloadWishlistItems(h.db))
+	if !ok {
+		return
+	}
+
+	view, err := newWishlistView(h.db, *wishlist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wishlist": view})
+}
+
+// UpdateWishlist renames a wishlist or changes whether it is shared. Making a
+// wishlist private revokes its link; sharing it again creates a new one.
+func (h *WishlistHandler) UpdateWishlist(c *gin.Context) {
+	wishlist, ok := h.findWishlist(c, h.db)
+	if !ok {
+		return
+	}
+
+	var req UpdateWishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name cannot be empty"})
+			return
+		}
+		wishlist.Name = *req.Name
+	}
+	if req.IsPublic != nil {
+		if *req.IsPublic && wishlist.SaveForLater {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The save-for-later list cannot be shared"})
+			return
+		}
+		if *req.IsPublic && wishlist.ShareToken == nil {
+			token, err := newShareToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist"})
+				return
+			}
+			wishlist.ShareToken = token
+		}
+		if !*req.IsPublic {
+			wishlist.ShareToken = nil
+		}
+		wishlist.IsPublic = *req.IsPublic
+	}
+
+	if err := h.db.Save(wishlist).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wishlist": wishlist})
+}
+
+func (h *WishlistHandler) DeleteWishlist(c *gin.Context) {
+	wishlist, ok := h.findWishlist(c, h.db)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("wishlist_id = ?", wishlist.ID).Delete(&models.WishlistItem{}).Error; err != nil {
+			return err
+		}
+		// Free the share token so that the link stops working
+		if err := tx.Model(wishlist).Update("share_token", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(wishlist).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wishlist deleted successfully"})
+}
+
+// GetSharedWishlist shows a public wishlist to anyone with its link.
+func (h *WishlistHandler) GetSharedWishlist(c *gin.Context) {
+	var wishlist models.Wishlist
+	if err := preloadWishlistItems(h.db).Preload("User").
+		Where("share_token = ? AND is_public = ?", c.Param("token"), true).First(&wishlist).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+		return
+	}
+
+	view, err := newWishlistView(h.db, wishlist)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check stock"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"wishlist": view,
+		"owner":    gin.H{"first_name": wishlist.User.FirstName},
+	})
+}
+
+func (h *WishlistHandler) AddWishlistItem(c *gin.Context) {
+	wishlist, ok := h.findWishlist(c, h.db)
+	if !ok {
+		return
+	}
+
+	var req AddWishlistItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Quantity == 0 {
+		req.Quantity = 1
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND is_active = ? AND is_hidden = ?", req.ProductID, true, false).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	variant, err := loadVariant(h.db, product.ID, req.VariantID)
+	if err != nil {
+		if err == errVariantRequired {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Variant is required for this product"})
+			return
+		}
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Variant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variant"})
+		return
+	}
+
+	price := product.Price
+	if variant != nil {
+		price = variant.EffectivePrice(product)
+	}
+
+	item, err := addWishlistItem(h.db, wishlist.ID, product.ID, req.VariantID, req.Quantity, price)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add item to wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"item": item})
+}
+
+// findWishlistItem loads an item of the wishlist from the :item_id parameter
+// and writes the error response if it cannot.
+func (h *WishlistHandler) findWishlistItem(c *gin.Context, wishlist *models.Wishlist) (*models.WishlistItem, bool) {
+	itemID, err := strconv.ParseUint(c.Param("item_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wishlist item ID"})
+		return nil, false
+	}
+
+	var item models.WishlistItem
+	if err := h.db.Where("id = ? AND wishlist_id = ?", itemID, wishlist.ID).First(&item).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist item"})
+		return nil, false
+	}
+	return &item, true
+}
+
+func (h *WishlistHandler) RemoveWishlistItem(c *gin.Context) {
+	wishlist, ok := h.findWishlist(c, h.db)
+	if !ok {
+		return
+	}
+	item, ok := h.findWishlistItem(c, wishlist)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(item).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove item from wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item removed from wishlist successfully"})
+}
+
+// MoveToCart moves a wishlist item into the caller's cart.
+func (h *WishlistHandler) MoveToCart(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	wishlist, ok := h.findWishlist(c, h.db)
+	if !ok {
+		return
+	}
+	item, ok := h.findWishlistItem(c, wishlist)
+	if !ok {
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND is_active = ? AND is_hidden = ?", item.ProductID, true, false).First(&product).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This product is no longer available"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return
+	}
+
+	variant, err := loadVariant(h.db, product.ID, item.VariantID)
+	if err != nil {
+		if err == errVariantRequired || err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This product is no longer available"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch variant"})
+		return
+	}
+
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart
+		if err := tx.Where("user_id = ?", userID).First(&cart).Error; err != nil {
+			if err != gorm.ErrRecordNotFound {
+				return err
+			}
+			cart = models.Cart{UserID: &userID}
+			if err := tx.Create(&cart).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := addCartItem(tx, cart.ID, product, variant, item.Quantity); err != nil {
+			return err
+		}
+		return tx.Delete(item).Error
+	}); err != nil {
+		if err == services.ErrInsufficientStock {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient stock"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to cart"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item moved to cart successfully"})
+}
+
+// SaveForLater moves a cart item to the caller's save-for-later list.
+func (h *WishlistHandler) SaveForLater(c *gin.Context) {
+	h.moveCartItem(c, func(tx *gorm.DB, userID uint) (*models.Wishlist, error) {
+		return saveForLaterList(tx, userID)
+	})
+}
+
+// MoveToWishlist moves a cart item to one of the caller's wishlists.
+func (h *WishlistHandler) MoveToWishlist(c *gin.Context) {
+	var req MoveToWishlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.moveCartItem(c, func(tx *gorm.DB, userID uint) (*models.Wishlist, error) {
+		var wishlist models.Wishlist
+		if err := tx.Where("id = ? AND user_id = ?", req.WishlistID, userID).First(&wishlist).Error; err != nil {
+			return nil, err
+		}
+		return &wishlist, nil
+	})
+}
+
+// moveCartItem moves the cart item named by the :id parameter to the
+// wishlist returned by target, releasing any stock reserved for it.
+func (h *WishlistHandler) moveCartItem(c *gin.Context, target func(tx *gorm.DB, userID uint) (*models.Wishlist, error)) {
+	userID := c.MustGet("user_id").(uint)
+
+	cartItemID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cart item ID"})
+		return
+	}
+
+	var cartItem models.CartItem
+	if err := h.db.Preload("Product").Preload("Variant").
+		Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Where("cart_items.id = ? AND carts.user_id = ?", cartItemID, userID).
+		First(&cartItem).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart item"})
+		return
+	}
+
+	var wishlist *models.Wishlist
+	var item *models.WishlistItem
+	if err := h.db.Transaction(func(tx *gorm.DB) error {
+		if wishlist, err = target(tx, userID); err != nil {
+			return err
+		}
+
+		price := cartItem.PriceAtAdd
+		if price == 0 {
+			price = cartItem.UnitPrice()
+		}
+		if item, err = addWishlistItem(tx, wishlist.ID, cartItem.ProductID, cartItem.VariantID, cartItem.Quantity, price); err != nil {
+			return err
+		}
+
+		if err := tx.Where("cart_item_id = ?", cartItem.ID).Delete(&models.StockReservation{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.CartItem{}, cartItem.ID).Error
+	}); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move item to wishlist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"wishlist_id": wishlist.ID, "item": item})
+}
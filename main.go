@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"ecommerce-app/config"
 	"ecommerce-app/handlers"
@@ -27,32 +28,105 @@ func main() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	// Rating aggregates are backfilled once, when their columns are added
+	backfillRatings := !db.Migrator().HasColumn(&models.Product{}, "review_count")
+
 	// Auto migrate database
 	if err := db.AutoMigrate(
 		&models.User{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductOption{},
+		&models.ProductOptionValue{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
 		&models.Order{},
 		&models.OrderItem{},
 		&models.Cart{},
 		&models.CartItem{},
+		&models.StockReservation{},
+		&models.CartReminder{},
+		&models.Wishlist{},
+		&models.WishlistItem{},
+		&models.ProductAlert{},
 		&models.Review{},
+		&models.ReviewVote{},
+		&models.ReviewResponse{},
+		&models.ReviewPhoto{},
+		&models.Conversation{},
+		&models.ConversationParticipant{},
 		&models.Message{},
+		&models.MessageAttachment{},
+		&models.MessageEdit{},
+		&models.UserBlock{},
+		&models.ContentReport{},
+		&models.ModerationAction{},
+		&models.ImportJob{},
+		&models.InventoryMovement{},
 	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	// Map legacy free-text product categories onto the category tree
+	if err := config.MigrateProductCategories(db); err != nil {
+		log.Fatal("Failed to migrate product categories:", err)
+	}
+
+	// Let the slugs of deleted categories be reused
+	if err := config.MigrateCategorySlugs(db); err != nil {
+		log.Fatal("Failed to migrate category slugs:", err)
+	}
+
+	// Give stock that predates the inventory ledger an opening balance
+	if err := config.MigrateInventoryLedger(db); err != nil {
+		log.Fatal("Failed to migrate inventory ledger:", err)
+	}
+
+	// Scope variant SKUs to their product and free those of deleted variants
+	if err := config.MigrateVariantSKUs(db); err != nil {
+		log.Fatal("Failed to migrate variant SKUs:", err)
+	}
+
+	// Flag reviews of delivered purchases written before they were tracked
+	if err := config.MigrateVerifiedPurchases(db); err != nil {
+		log.Fatal("Failed to migrate verified purchases:", err)
+	}
+
+	// Move messages written before conversations into conversations
+	if err := config.MigrateConversations(db); err != nil {
+		log.Fatal("Failed to migrate conversations:", err)
+	}
+
+	// Build the rating aggregates of products from the existing reviews
+	if backfillRatings {
+		if err := services.RecalculateProductRatings(db); err != nil {
+			log.Fatal("Failed to recalculate product ratings:", err)
+		}
+	}
+
 	// Initialize services
 	emailService := services.NewEmailService()
 	paymentService := services.NewPaymentService()
 	websocketService := services.NewWebSocketService()
+	storage := services.NewStorage()
+	imageProcessor := services.NewImageProcessor()
+	productAlerts := services.NewProductAlertService(db, emailService, websocketService)
+	productSearch := services.NewProductSearch(db)
+	if err := productSearch.Setup(); err != nil {
+		log.Fatal("Failed to set up product search:", err)
+	}
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, emailService)
-	productHandler := handlers.NewProductHandler(db)
-	orderHandler := handlers.NewOrderHandler(db, paymentService)
+	productHandler := handlers.NewProductHandler(db, productSearch, storage, imageProcessor, productAlerts)
+	categoryHandler := handlers.NewCategoryHandler(db)
+	uploadHandler := handlers.NewUploadHandler(storage)
+	orderHandler := handlers.NewOrderHandler(db, paymentService, productAlerts)
 	cartHandler := handlers.NewCartHandler(db)
-	reviewHandler := handlers.NewReviewHandler(db)
-	messageHandler := handlers.NewMessageHandler(db, websocketService)
+	wishlistHandler := handlers.NewWishlistHandler(db)
+	reviewHandler := handlers.NewReviewHandler(db, emailService, websocketService, storage, imageProcessor)
+	messageHandler := handlers.NewMessageHandler(db, websocketService, storage)
+	moderationHandler := handlers.NewModerationHandler(db, storage)
 
 	// Initialize middleware
 	authMiddleware := middleware.AuthMiddleware()
@@ -64,7 +138,8 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Cart-Token")
+		c.Header("Access-Control-Expose-Headers", "X-Cart-Token")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -75,11 +150,17 @@ func main() {
 	})
 
 	// Setup routes
-	routes.SetupRoutes(router, db, authHandler, productHandler, orderHandler, cartHandler, reviewHandler, messageHandler, websocketService, authMiddleware)
+	routes.SetupRoutes(router, db, authHandler, productHandler, categoryHandler, uploadHandler, orderHandler, cartHandler, wishlistHandler, reviewHandler, messageHandler, moderationHandler, websocketService, authMiddleware)
 
 	// Start WebSocket hub
 	go websocketService.StartHub()
 
+	// Release checkout stock reservations once they expire
+	go services.StartReservationSweeper(db, time.Minute)
+
+	// Remind users about carts they left behind
+	go services.StartCartReminderScheduler(db, emailService, time.Hour)
+
 	// Get port from environment
 	port := os.Getenv("SERVER_PORT")
 	if port == "" {
@@ -21,62 +21,103 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required"})
-			c.Abort()
+		authenticate(c, authHeader)
+	}
+}
+
+// OptionalAuthMiddleware authenticates requests that carry an Authorization
+// header like AuthMiddleware and lets anonymous requests through without a
+// user_id, for routes that guests may use as well.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.Next()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		authenticate(c, authHeader)
+	}
+}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
+// authenticate validates the bearer token in authHeader and stores the user
+// in the context, aborting the request when the token is not acceptable.
+func authenticate(c *gin.Context, authHeader string) {
+	tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token required"})
+		c.Abort()
+		return
+	}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
-		}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
 
-		userID, ok := claims["user_id"].(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-			c.Abort()
-			return
-		}
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
 
-		// Get database from context
-		db := c.MustGet("db").(*gorm.DB)
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.Abort()
+		return
+	}
 
-		// Get user from database
-		var user models.User
-		if err := db.First(&user, uint(userID)).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+		c.Abort()
+		return
+	}
+
+	// Get database from context
+	db := c.MustGet("db").(*gorm.DB)
+
+	// Get user from database
+	var user models.User
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		c.Abort()
+		return
+	}
+
+	// Check if email is confirmed
+	if !user.IsEmailConfirmed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email not confirmed"})
+		c.Abort()
+		return
+	}
+
+	c.Set("user", user)
+	c.Set("user_id", uint(userID))
+	c.Next()
+}
+
+func RequireEmailConfirmation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 			c.Abort()
 			return
 		}
 
-		// Check if email is confirmed
-		if !user.IsEmailConfirmed {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Email not confirmed"})
+		userModel := user.(models.User)
+		if !userModel.IsEmailConfirmed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email confirmation required"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user", user)
-		c.Set("user_id", uint(userID))
 		c.Next()
 	}
 }
 
-func RequireEmailConfirmation() gin.HandlerFunc {
+func RequireAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
@@ -86,8 +127,8 @@ func RequireEmailConfirmation() gin.HandlerFunc {
 		}
 
 		userModel := user.(models.User)
-		if !userModel.IsEmailConfirmed {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Email confirmation required"})
+		if !userModel.IsAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
 			c.Abort()
 			return
 		}
@@ -6,28 +6,72 @@ import (
 	"gorm.io/gorm"
 )
 
+// Cart belongs either to a user or, through GuestID, to a visitor who is not
+// logged in. The guest ID is only handed out inside a signed cart token.
 type Cart struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
-	UserID    uint           `json:"user_id" gorm:"unique;not null"`
+	UserID    *uint          `json:"user_id" gorm:"unique"`
+	GuestID   *string        `json:"-" gorm:"uniqueIndex"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
-	User      User       `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	User      *User      `json:"user,omitempty" gorm:"foreignKey:UserID"`
 	CartItems []CartItem `json:"cart_items,omitempty" gorm:"foreignKey:CartID"`
 }
 
+// CartItem is a product, or one of its variants, in a cart. PriceAtAdd is
+// the unit price when the item was last added, so price changes can be
+// pointed out before checkout; it is zero for items added before snapshots.
 type CartItem struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	CartID    uint           `json:"cart_id" gorm:"not null"`
-	ProductID uint           `json:"product_id" gorm:"not null"`
-	Quantity  int            `json:"quantity" gorm:"not null;default:1"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	CartID     uint           `json:"cart_id" gorm:"not null"`
+	ProductID  uint           `json:"product_id" gorm:"not null"`
+	VariantID  *uint          `json:"variant_id"`
+	Quantity   int            `json:"quantity" gorm:"not null;default:1"`
+	PriceAtAdd float64        `json:"price_at_add"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
-	Cart    Cart    `json:"cart,omitempty" gorm:"foreignKey:CartID"`
-	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Cart    Cart            `json:"cart,omitempty" gorm:"foreignKey:CartID"`
+	Product Product         `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Variant *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+}
+
+// UnitPrice returns the current price of the item, honoring the variant
+// price override when the item points at a variant.
+func (i CartItem) UnitPrice() float64 {
+	if i.Variant != nil {
+		return i.Variant.EffectivePrice(i.Product)
+	}
+	return i.Product.Price
+}
+
+// StockReservation holds stock for a cart item while its owner checks out.
+// Reservations that have not expired are subtracted from the stock other
+// shoppers can put in their carts or order.
+type StockReservation struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CartID     uint      `json:"cart_id" gorm:"not null;index"`
+	CartItemID uint      `json:"cart_item_id" gorm:"not null;uniqueIndex"`
+	ProductID  uint      `json:"product_id" gorm:"not null;index"`
+	VariantID  *uint     `json:"variant_id" gorm:"index"`
+	Quantity   int       `json:"quantity" gorm:"not null"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CartReminder records a reminder email about an abandoned cart. When the
+// cart is later ordered its open reminders are marked as converted.
+type CartReminder struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	CartID      uint       `json:"cart_id" gorm:"not null;index"`
+	UserID      uint       `json:"user_id" gorm:"not null;index"`
+	SentAt      time.Time  `json:"sent_at"`
+	ConvertedAt *time.Time `json:"converted_at"`
+	OrderID     *uint      `json:"order_id" gorm:"index"`
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+type Category struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name" gorm:"not null"`
+	Slug        string         `json:"slug" gorm:"uniqueIndex:idx_categories_active_slug,where:deleted_at IS NULL;not null"`
+	Description string         `json:"description"`
+	ParentID    *uint          `json:"parent_id" gorm:"index"`
+	Position    int            `json:"position" gorm:"default:0"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Parent   *Category  `json:"parent,omitempty" gorm:"foreignKey:ParentID"`
+	Children []Category `json:"children,omitempty" gorm:"foreignKey:ParentID"`
+}
+
+// Slugify turns a category name into a lowercase, hyphen separated slug so
+// that "Running Shoes" and "running  shoes" map to the same category.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
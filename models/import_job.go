@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ImportJobStatus string
+
+const (
+	ImportJobStatusPending   ImportJobStatus = "pending"
+	ImportJobStatusRunning   ImportJobStatus = "running"
+	ImportJobStatusCompleted ImportJobStatus = "completed"
+	ImportJobStatusFailed    ImportJobStatus = "failed"
+)
+
+// ImportRowError reports why a row of an import file was rejected. Row 1 is
+// the header, so data rows start at 2 like in a spreadsheet.
+type ImportRowError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// ImportJob tracks a bulk product import processed in the background.
+type ImportJob struct {
+	ID            uint             `json:"id" gorm:"primaryKey"`
+	UserID        uint             `json:"user_id" gorm:"not null;index"`
+	Status        ImportJobStatus  `json:"status" gorm:"default:'pending'"`
+	DryRun        bool             `json:"dry_run"`
+	TotalRows     int              `json:"total_rows"`
+	ProcessedRows int              `json:"processed_rows"`
+	Created       int              `json:"created"`
+	Updated       int              `json:"updated"`
+	RowErrors     []ImportRowError `json:"errors" gorm:"serializer:json"`
+	FailureReason string           `json:"failure_reason,omitempty"`
+	CompletedAt   *time.Time       `json:"completed_at"`
+	CreatedAt     time.Time        `json:"created_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt   `json:"-" gorm:"index"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+type InventoryMovementType string
+
+const (
+	InventoryMovementSale         InventoryMovementType = "sale"
+	InventoryMovementRestock      InventoryMovementType = "restock"
+	InventoryMovementAdjustment   InventoryMovementType = "adjustment"
+	InventoryMovementReturn       InventoryMovementType = "return"
+	InventoryMovementCancellation InventoryMovementType = "cancellation"
+)
+
+// InventoryMovement is an entry of the stock ledger. Quantity is signed:
+// sales remove stock, restocks, returns and cancellations add it back. The
+// sum of the movements of a product (or of one of its variants) equals its
+// stock. A nil ActorID means the movement was made by the system.
+type InventoryMovement struct {
+	ID         uint                  `json:"id" gorm:"primaryKey"`
+	ProductID  uint                  `json:"product_id" gorm:"not null;index"`
+	VariantID  *uint                 `json:"variant_id" gorm:"index"`
+	Type       InventoryMovementType `json:"type" gorm:"not null"`
+	Quantity   int                   `json:"quantity" gorm:"not null"`
+	StockAfter int                   `json:"stock_after"`
+	Reason     string                `json:"reason"`
+	ActorID    *uint                 `json:"actor_id"`
+	OrderID    *uint                 `json:"order_id" gorm:"index"`
+	CreatedAt  time.Time             `json:"created_at"`
+
+	// Relationships
+	Product Product         `json:"-" gorm:"foreignKey:ProductID"`
+	Variant *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+	Actor   *User           `json:"actor,omitempty" gorm:"foreignKey:ActorID"`
+}
@@ -6,17 +6,90 @@ import (
 	"gorm.io/gorm"
 )
 
+// Conversation is a message thread between its participants, optionally
+// about a product or an order. The same users can have one conversation
+// per product, one per order and one about nothing in particular.
+// StartedByID is the user who sent the first message.
+type Conversation struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	ProductID     *uint     `json:"product_id" gorm:"index"`
+	OrderID       *uint     `json:"order_id" gorm:"index"`
+	StartedByID   uint      `json:"started_by_id" gorm:"not null;default:0;index"`
+	LastMessageAt time.Time `json:"last_message_at" gorm:"index"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Relationships
+	Participants []ConversationParticipant `json:"participants,omitempty" gorm:"foreignKey:ConversationID"`
+	Product      *Product                  `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Order        *Order                    `json:"order,omitempty" gorm:"foreignKey:OrderID"`
+}
+
+// ConversationParticipant is a user taking part in a conversation. A
+// participant who muted the conversation gets no real-time pushes for it.
+type ConversationParticipant struct {
+	ID             uint       `json:"id" gorm:"primaryKey"`
+	ConversationID uint       `json:"conversation_id" gorm:"not null;uniqueIndex:idx_conversation_participant"`
+	UserID         uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_conversation_participant;index"`
+	MutedAt        *time.Time `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Relationships
+	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
+}
+
+// Message is a message in a conversation. Either participant can delete it
+// for themselves only; unsending it deletes it for both.
 type Message struct {
-	ID         uint           `json:"id" gorm:"primaryKey"`
-	FromUserID uint           `json:"from_user_id" gorm:"not null"`
-	ToUserID   uint           `json:"to_user_id" gorm:"not null"`
-	Content    string         `json:"content" gorm:"not null"`
-	IsRead     bool           `json:"is_read" gorm:"default:false"`
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                  uint           `json:"id" gorm:"primaryKey"`
+	ConversationID      uint           `json:"conversation_id" gorm:"not null;default:0;index"`
+	FromUserID          uint           `json:"from_user_id" gorm:"not null"`
+	ToUserID            uint           `json:"to_user_id" gorm:"not null"`
+	Content             string         `json:"content" gorm:"not null"`
+	IsRead              bool           `json:"is_read" gorm:"default:false"`
+	IsHidden            bool           `json:"is_hidden" gorm:"default:false;index"`
+	EditedAt            *time.Time     `json:"edited_at"`
+	DeletedForSender    bool           `json:"-" gorm:"default:false"`
+	DeletedForRecipient bool           `json:"-" gorm:"default:false"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
-	FromUser User `json:"from_user,omitempty" gorm:"foreignKey:FromUserID"`
-	ToUser   User `json:"to_user,omitempty" gorm:"foreignKey:ToUserID"`
+	FromUser    User                `json:"from_user,omitempty" gorm:"foreignKey:FromUserID"`
+	ToUser      User                `json:"to_user,omitempty" gorm:"foreignKey:ToUserID"`
+	Attachments []MessageAttachment `json:"attachments,omitempty" gorm:"foreignKey:MessageID"`
+}
+
+// MessageAttachment is a file sent with a message. Attachments are kept out
+// of the public uploads and only the participants of the conversation can
+// download them.
+type MessageAttachment struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	MessageID   uint      `json:"message_id" gorm:"not null;index"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	StorageKey  string    `json:"-" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UserBlock stops BlockedUserID from messaging UserID, and hides their
+// conversations from UserID's inbox.
+type UserBlock struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	UserID        uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_user_block"`
+	BlockedUserID uint      `json:"blocked_user_id" gorm:"not null;uniqueIndex:idx_user_block;index"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// Relationships
+	BlockedUser User `json:"blocked_user,omitempty" gorm:"foreignKey:BlockedUserID"`
+}
+
+// MessageEdit keeps the content a message had before an edit.
+type MessageEdit struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	MessageID uint      `json:"message_id" gorm:"not null;index"`
+	Content   string    `json:"content" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+)
+
+// ContentType names the kinds of user content that can be reported.
+type ContentType string
+
+const (
+	ContentTypeReview  ContentType = "review"
+	ContentTypeProduct ContentType = "product"
+	ContentTypeMessage ContentType = "message"
+)
+
+type ContentReportStatus string
+
+const (
+	ContentReportOpen     ContentReportStatus = "open"
+	ContentReportResolved ContentReportStatus = "resolved"
+)
+
+// ContentReport is a user's report of a review, product or message. Open
+// reports make up the moderation queue; a moderation decision resolves all
+// open reports of the content.
+type ContentReport struct {
+	ID          uint                `json:"id" gorm:"primaryKey"`
+	ContentType ContentType         `json:"content_type" gorm:"not null;uniqueIndex:idx_content_report;index:idx_content_report_content"`
+	ContentID   uint                `json:"content_id" gorm:"not null;uniqueIndex:idx_content_report;index:idx_content_report_content"`
+	ReporterID  uint                `json:"reporter_id" gorm:"not null;uniqueIndex:idx_content_report"`
+	Reason      string              `json:"reason" gorm:"not null"`
+	Status      ContentReportStatus `json:"status" gorm:"not null;default:'open';index"`
+	CreatedAt   time.Time           `json:"created_at"`
+	ResolvedAt  *time.Time          `json:"resolved_at"`
+
+	// Relationships
+	Reporter User `json:"reporter,omitempty" gorm:"foreignKey:ReporterID"`
+}
+
+type ModerationActionType string
+
+const (
+	ModerationApprove  ModerationActionType = "approve"
+	ModerationHide     ModerationActionType = "hide"
+	ModerationDelete   ModerationActionType = "delete"
+	ModerationAutoHide ModerationActionType = "auto_hide"
+)
+
+// ModerationAction is an entry of the moderation audit trail. A nil
+// ModeratorID means the action was taken automatically.
+type ModerationAction struct {
+	ID          uint                 `json:"id" gorm:"primaryKey"`
+	ContentType ContentType          `json:"content_type" gorm:"not null;index:idx_moderation_action_content"`
+	ContentID   uint                 `json:"content_id" gorm:"not null;index:idx_moderation_action_content"`
+	Action      ModerationActionType `json:"action" gorm:"not null"`
+	ModeratorID *uint                `json:"moderator_id"`
+	Reason      string               `json:"reason"`
+	ReportCount int                  `json:"report_count"`
+	CreatedAt   time.Time            `json:"created_at"`
+
+	// Relationships
+	Moderator *User `json:"moderator,omitempty" gorm:"foreignKey:ModeratorID"`
+}
@@ -38,6 +38,8 @@ type OrderItem struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
 	OrderID   uint           `json:"order_id" gorm:"not null"`
 	ProductID uint           `json:"product_id" gorm:"not null"`
+	VariantID *uint          `json:"variant_id"`
+	SKU       string         `json:"sku"`
 	Quantity  int            `json:"quantity" gorm:"not null"`
 	Price     float64        `json:"price" gorm:"not null"`
 	CreatedAt time.Time      `json:"created_at"`
@@ -46,5 +48,6 @@ type OrderItem struct {
 
 	// Relationships
 	Order   Order   `json:"order,omitempty" gorm:"foreignKey:OrderID"`
-	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Product Product         `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Variant *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
 }
@@ -8,21 +8,130 @@ import (
 
 type Product struct {
 	ID          uint           `json:"id" gorm:"primaryKey"`
+	SKU         *string        `json:"sku" gorm:"uniqueIndex:idx_products_user_sku,priority:2"`
 	Name        string         `json:"name" gorm:"not null"`
 	Description string         `json:"description"`
 	Price       float64        `json:"price" gorm:"not null"`
 	Stock       int            `json:"stock" gorm:"not null;default:0"`
 	ImageURL    string         `json:"image_url"`
-	Category    string         `json:"category"`
-	UserID      uint           `json:"user_id" gorm:"not null"`
+	CategoryID  *uint          `json:"category_id" gorm:"index"`
+	UserID      uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_products_user_sku,priority:1"`
 	IsActive    bool           `json:"is_active" gorm:"default:true"`
+	IsHidden    bool           `json:"is_hidden" gorm:"default:false;index"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 
+	// Rating aggregates, kept up to date with the product's reviews
+	AverageRating float64 `json:"average_rating" gorm:"not null;default:0;index"`
+	ReviewCount   int     `json:"review_count" gorm:"not null;default:0"`
+	Rating1Count  int     `json:"-" gorm:"column:rating1_count;not null;default:0"`
+	Rating2Count  int     `json:"-" gorm:"column:rating2_count;not null;default:0"`
+	Rating3Count  int     `json:"-" gorm:"column:rating3_count;not null;default:0"`
+	Rating4Count  int     `json:"-" gorm:"column:rating4_count;not null;default:0"`
+	Rating5Count  int     `json:"-" gorm:"column:rating5_count;not null;default:0"`
+
 	// Relationships
 	User       User         `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Category   *Category    `json:"category,omitempty" gorm:"foreignKey:CategoryID"`
 	OrderItems []OrderItem  `json:"order_items,omitempty" gorm:"foreignKey:ProductID"`
 	CartItems  []CartItem   `json:"cart_items,omitempty" gorm:"foreignKey:ProductID"`
 	Reviews    []Review     `json:"reviews,omitempty" gorm:"foreignKey:ProductID"`
+	Options    []ProductOption  `json:"options,omitempty" gorm:"foreignKey:ProductID"`
+	Variants   []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID"`
+	Images     []ProductImage   `json:"images,omitempty" gorm:"foreignKey:ProductID"`
+}
+
+// IsAvailable reports whether the product is for sale: active and not
+// hidden by moderation.
+func (p Product) IsAvailable() bool {
+	return p.IsActive && !p.IsHidden
+}
+
+// RatingHistogram returns the number of reviews per star rating.
+func (p Product) RatingHistogram() map[int]int {
+	return map[int]int{
+		1: p.Rating1Count,
+		2: p.Rating2Count,
+		3: p.Rating3Count,
+		4: p.Rating4Count,
+		5: p.Rating5Count,
+	}
+}
+
+// ProductImage is an uploaded product photo. The image at the lowest
+// position is the primary image and is mirrored into Product.ImageURL.
+type ProductImage struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	ProductID    uint           `json:"product_id" gorm:"not null;index"`
+	Position     int            `json:"position" gorm:"default:0"`
+	URL          string         `json:"url" gorm:"not null"`
+	ThumbnailURL string         `json:"thumbnail_url"`
+	StorageKey   string         `json:"-" gorm:"not null"`
+	ThumbnailKey string         `json:"-"`
+	ContentType  string         `json:"content_type"`
+	Size         int64          `json:"size"`
+	Width        int            `json:"width"`
+	Height       int            `json:"height"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// ProductOption is an option type such as "size" or "color" offered by a product.
+type ProductOption struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	ProductID uint           `json:"product_id" gorm:"not null;index"`
+	Name      string         `json:"name" gorm:"not null"`
+	Position  int            `json:"position" gorm:"default:0"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Values []ProductOptionValue `json:"values,omitempty" gorm:"foreignKey:OptionID"`
+}
+
+// ProductOptionValue is a single choice of an option, e.g. "XL" for "size".
+type ProductOptionValue struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	OptionID  uint           `json:"option_id" gorm:"not null;index"`
+	Value     string         `json:"value" gorm:"not null"`
+	Position  int            `json:"position" gorm:"default:0"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Option ProductOption `json:"-" gorm:"foreignKey:OptionID"`
+}
+
+// ProductVariant is a purchasable combination of option values with its own
+// SKU and stock. A nil Price means the product price applies. SKUs are unique
+// within the product and cleared when the variant is deleted so they can be
+// reused.
+type ProductVariant struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	ProductID uint           `json:"product_id" gorm:"not null;uniqueIndex:idx_product_variants_product_sku,priority:1"`
+	SKU       *string        `json:"sku" gorm:"uniqueIndex:idx_product_variants_product_sku,priority:2"`
+	Price     *float64       `json:"price"`
+	Stock     int            `json:"stock" gorm:"not null;default:0"`
+	ImageURL  string         `json:"image_url"`
+	IsActive  bool           `json:"is_active" gorm:"default:true"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Product      Product              `json:"-" gorm:"foreignKey:ProductID"`
+	OptionValues []ProductOptionValue `json:"option_values,omitempty" gorm:"many2many:product_variant_option_values;"`
+}
+
+// EffectivePrice returns the variant price override, falling back to the
+// price of the parent product.
+func (v ProductVariant) EffectivePrice(product Product) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return product.Price
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+type ProductAlertType string
+
+const (
+	ProductAlertRestock   ProductAlertType = "restock"
+	ProductAlertPriceDrop ProductAlertType = "price_drop"
+)
+
+// ProductAlert subscribes a user to a product. Restock alerts fire once,
+// when the product is back in stock, and are re-armed by subscribing again.
+// Price-drop alerts fire whenever the price falls below Price, the price at
+// subscription or at the last alert, which is then lowered to the new price.
+type ProductAlert struct {
+	ID         uint             `json:"id" gorm:"primaryKey"`
+	UserID     uint             `json:"user_id" gorm:"not null;uniqueIndex:idx_product_alert"`
+	ProductID  uint             `json:"product_id" gorm:"not null;uniqueIndex:idx_product_alert;index"`
+	Type       ProductAlertType `json:"type" gorm:"not null;uniqueIndex:idx_product_alert"`
+	Price      float64          `json:"price"`
+	NotifiedAt *time.Time       `json:"notified_at"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+
+	// Relationships
+	User    User    `json:"-" gorm:"foreignKey:UserID"`
+	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+}
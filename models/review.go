@@ -6,17 +6,65 @@ import (
 	"gorm.io/gorm"
 )
 
+// Review is a rating of a product. VerifiedPurchase is set when the author
+// has a delivered order of the product. HelpfulCount and UnhelpfulCount
+// count the ReviewVotes of other users.
 type Review struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	UserID    uint           `json:"user_id" gorm:"not null"`
-	ProductID uint           `json:"product_id" gorm:"not null"`
-	Rating    int            `json:"rating" gorm:"not null;check:rating >= 1 AND rating <= 5"`
-	Comment   string         `json:"comment"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	UserID           uint           `json:"user_id" gorm:"not null"`
+	ProductID        uint           `json:"product_id" gorm:"not null"`
+	Rating           int            `json:"rating" gorm:"not null;check:rating >= 1 AND rating <= 5"`
+	Comment          string         `json:"comment"`
+	VerifiedPurchase bool           `json:"verified_purchase" gorm:"default:false;index"`
+	HelpfulCount     int            `json:"helpful_count" gorm:"not null;default:0"`
+	UnhelpfulCount   int            `json:"unhelpful_count" gorm:"not null;default:0"`
+	IsHidden         bool           `json:"is_hidden" gorm:"default:false;index"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
-	User    User    `json:"user,omitempty" gorm:"foreignKey:UserID"`
-	Product Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	User     User            `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Product  Product         `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Response *ReviewResponse `json:"response,omitempty" gorm:"foreignKey:ReviewID"`
+	Photos   []ReviewPhoto   `json:"photos,omitempty" gorm:"foreignKey:ReviewID"`
+}
+
+// ReviewVote is a user's helpful or unhelpful vote on a review. Every user
+// has at most one vote per review.
+type ReviewVote struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ReviewID  uint      `json:"review_id" gorm:"not null;uniqueIndex:idx_review_vote"`
+	UserID    uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_review_vote"`
+	Helpful   bool      `json:"helpful"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReviewResponse is the public reply of the product's seller to a review.
+// A review has at most one response.
+type ReviewResponse struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	ReviewID  uint      `json:"review_id" gorm:"not null;uniqueIndex"`
+	UserID    uint      `json:"user_id" gorm:"not null"`
+	Body      string    `json:"body" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReviewPhoto is an image attached to a review by its author, stored like
+// product images along with a thumbnail.
+type ReviewPhoto struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	ReviewID     uint      `json:"review_id" gorm:"not null;index"`
+	Position     int       `json:"position" gorm:"default:0"`
+	URL          string    `json:"url" gorm:"not null"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	StorageKey   string    `json:"-" gorm:"not null"`
+	ThumbnailKey string    `json:"-"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	CreatedAt    time.Time `json:"created_at"`
 }
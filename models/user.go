@@ -13,8 +13,10 @@ type User struct {
 	FirstName         string         `json:"first_name" gorm:"not null"`
 	LastName          string         `json:"last_name" gorm:"not null"`
 	IsEmailConfirmed  bool           `json:"is_email_confirmed" gorm:"default:false"`
+	IsAdmin           bool           `json:"is_admin" gorm:"default:false"`
 	EmailConfirmToken string         `json:"-"`
 	ResetPasswordToken string        `json:"-"`
+	CartRemindersOptOut bool         `json:"cart_reminders_opt_out" gorm:"default:false"`
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `json:"-" gorm:"index"`
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Wishlist is a named list of products kept by a user. Public wishlists can
+// be viewed by anyone who has their share token. Every user also has at most
+// one save-for-later list, which holds items moved out of the cart.
+type Wishlist struct {
+	ID           uint           `json:"id" gorm:"primaryKey"`
+	UserID       uint           `json:"user_id" gorm:"not null;index"`
+	Name         string         `json:"name" gorm:"not null"`
+	IsPublic     bool           `json:"is_public" gorm:"default:false"`
+	ShareToken   *string        `json:"share_token,omitempty" gorm:"uniqueIndex"`
+	SaveForLater bool           `json:"save_for_later" gorm:"default:false"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	User  User           `json:"-" gorm:"foreignKey:UserID"`
+	Items []WishlistItem `json:"items,omitempty" gorm:"foreignKey:WishlistID"`
+}
+
+// WishlistItem is a product, or one of its variants, on a wishlist.
+// PriceAtAdd records the unit price when it was added so that discounts can
+// be pointed out.
+type WishlistItem struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	WishlistID uint           `json:"wishlist_id" gorm:"not null;index"`
+	ProductID  uint           `json:"product_id" gorm:"not null"`
+	VariantID  *uint          `json:"variant_id"`
+	Quantity   int            `json:"quantity" gorm:"not null;default:1"`
+	PriceAtAdd float64        `json:"price_at_add"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"`
+
+	// Relationships
+	Wishlist Wishlist        `json:"-" gorm:"foreignKey:WishlistID"`
+	Product  Product         `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+	Variant  *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+}
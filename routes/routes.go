@@ -2,6 +2,7 @@ package routes
 
 import (
 	"ecommerce-app/handlers"
+	"ecommerce-app/middleware"
 	"ecommerce-app/services"
 	"strconv"
 
@@ -14,10 +15,14 @@ func SetupRoutes(
 	db *gorm.DB,
 	authHandler *handlers.AuthHandler,
 	productHandler *handlers.ProductHandler,
+	categoryHandler *handlers.CategoryHandler,
+	uploadHandler *handlers.UploadHandler,
 	orderHandler *handlers.OrderHandler,
 	cartHandler *handlers.CartHandler,
+	wishlistHandler *handlers.WishlistHandler,
 	reviewHandler *handlers.ReviewHandler,
 	messageHandler *handlers.MessageHandler,
+	moderationHandler *handlers.ModerationHandler,
 	websocketService *services.WebSocketService,
 	authMiddleware gin.HandlerFunc,
 ) {
@@ -38,8 +43,26 @@ func SetupRoutes(
 			auth.GET("/confirm-email", authHandler.ConfirmEmail)
 			auth.POST("/request-password-reset", authHandler.RequestPasswordReset)
 			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.PUT("/preferences", authMiddleware, authHandler.UpdatePreferences)
 		}
 
+		// Cart routes (guests identify their cart with the X-Cart-Token header)
+		cart := api.Group("/cart")
+		cart.Use(middleware.OptionalAuthMiddleware())
+		{
+			cart.GET("", cartHandler.GetCart)
+			cart.POST("/add", cartHandler.AddToCart)
+			cart.PUT("/items/:id", cartHandler.UpdateCartItem)
+			cart.DELETE("/items/:id", cartHandler.RemoveFromCart)
+			cart.DELETE("", cartHandler.ClearCart)
+			cart.POST("/checkout", cartHandler.StartCheckout)
+			cart.POST("/items/:id/save-for-later", authMiddleware, wishlistHandler.SaveForLater)
+			cart.POST("/items/:id/move-to-wishlist", authMiddleware, wishlistHandler.MoveToWishlist)
+		}
+
+		// Shared wishlists (anyone with the link)
+		api.GET("/wishlists/shared/:token", wishlistHandler.GetSharedWishlist)
+
 		// Protected routes (authentication required)
 		protected := api.Group("/")
 		protected.Use(authMiddleware)
@@ -53,16 +76,41 @@ func SetupRoutes(
 				products.PUT("/:id", productHandler.UpdateProduct)
 				products.DELETE("/:id", productHandler.DeleteProduct)
 				products.GET("/my", productHandler.GetMyProducts)
+				products.GET("/alerts", productHandler.GetMyProductAlerts)
+				products.GET("/export", productHandler.ExportProducts)
+				products.POST("/import", productHandler.ImportProducts)
+				products.GET("/import/:job_id", productHandler.GetImportJob)
+				products.POST("/:id/options", productHandler.CreateProductOption)
+				products.DELETE("/:id/options/:option_id", productHandler.DeleteProductOption)
+				products.POST("/:id/variants", productHandler.CreateVariant)
+				products.PUT("/:id/variants/:variant_id", productHandler.UpdateVariant)
+				products.DELETE("/:id/variants/:variant_id", productHandler.DeleteVariant)
+				products.POST("/:id/images", productHandler.UploadProductImages)
+				products.PUT("/:id/images/order", productHandler.ReorderProductImages)
+				products.DELETE("/:id/images/:image_id", productHandler.DeleteProductImage)
+				products.GET("/:id/inventory", productHandler.GetInventoryMovements)
+				products.POST("/:id/inventory", productHandler.AdjustStock)
+				products.POST("/:id/alerts", productHandler.SubscribeProductAlert)
+				products.DELETE("/:id/alerts/:type", productHandler.UnsubscribeProductAlert)
+			}
+
+			// Category routes
+			categories := protected.Group("/categories")
+			{
+				categories.GET("", categoryHandler.GetCategories)
+				categories.GET("/:id", categoryHandler.GetCategory)
 			}
 
-			// Cart routes
-			cart := protected.Group("/cart")
+			// Admin routes
+			admin := protected.Group("/admin")
+			admin.Use(middleware.RequireAdmin())
 			{
-				cart.GET("", cartHandler.GetCart)
-				cart.POST("/add", cartHandler.AddToCart)
-				cart.PUT("/items/:id", cartHandler.UpdateCartItem)
-				cart.DELETE("/items/:id", cartHandler.RemoveFromCart)
-				cart.DELETE("", cartHandler.ClearCart)
+				admin.POST("/categories", categoryHandler.CreateCategory)
+				admin.PUT("/categories/:id", categoryHandler.UpdateCategory)
+				admin.DELETE("/categories/:id", categoryHandler.DeleteCategory)
+				admin.GET("/moderation", moderationHandler.GetModerationQueue)
+				admin.GET("/moderation/log", moderationHandler.GetModerationLog)
+				admin.POST("/moderation/:type/:id", moderationHandler.ModerateContent)
 			}
 
 			// Order routes
@@ -75,14 +123,34 @@ func SetupRoutes(
 				orders.PUT("/:id/status", orderHandler.UpdateOrderStatus)
 			}
 
+			// Wishlist routes
+			wishlists := protected.Group("/wishlists")
+			{
+				wishlists.GET("", wishlistHandler.GetWishlists)
+				wishlists.POST("", wishlistHandler.CreateWishlist)
+				wishlists.GET("/:id", wishlistHandler.GetWishlist)
+				wishlists.PUT("/:id", wishlistHandler.UpdateWishlist)
+				wishlists.DELETE("/:id", wishlistHandler.DeleteWishlist)
+				wishlists.POST("/:id/items", wishlistHandler.AddWishlistItem)
+				wishlists.DELETE("/:id/items/:item_id", wishlistHandler.RemoveWishlistItem)
+				wishlists.POST("/:id/items/:item_id/move-to-cart", wishlistHandler.MoveToCart)
+			}
+
 			// Review routes
 			reviews := protected.Group("/reviews")
 			{
 				reviews.POST("", reviewHandler.CreateReview)
 				reviews.GET("/product/:id", reviewHandler.GetProductReviews)
+				reviews.GET("/product/:id/summary", reviewHandler.GetProductReviewSummary)
 				reviews.PUT("/:id", reviewHandler.UpdateReview)
 				reviews.DELETE("/:id", reviewHandler.DeleteReview)
 				reviews.GET("/my", reviewHandler.GetMyReviews)
+				reviews.POST("/:id/vote", reviewHandler.VoteReview)
+				reviews.DELETE("/:id/vote", reviewHandler.DeleteReviewVote)
+				reviews.PUT("/:id/response", reviewHandler.RespondToReview)
+				reviews.DELETE("/:id/response", reviewHandler.DeleteReviewResponse)
+				reviews.POST("/:id/photos", reviewHandler.UploadReviewPhotos)
+				reviews.DELETE("/:id/photos/:photo_id", reviewHandler.DeleteReviewPhoto)
 			}
 
 			// Message routes
@@ -90,17 +158,34 @@ func SetupRoutes(
 			{
 				messages.POST("", messageHandler.SendMessage)
 				messages.GET("/conversations", messageHandler.GetConversations)
+				messages.GET("/conversations/:id", messageHandler.GetConversationMessages)
+				messages.PUT("/conversations/:id/read", messageHandler.MarkConversationMessagesRead)
+				messages.PUT("/conversations/:id/mute", messageHandler.MuteConversation)
+				messages.DELETE("/conversations/:id/mute", messageHandler.UnmuteConversation)
 				messages.GET("/conversation/:user_id", messageHandler.GetConversation)
+				messages.PUT("/conversation/:user_id/read", messageHandler.MarkConversationRead)
 				messages.GET("/unread-count", messageHandler.GetUnreadCount)
 				messages.PUT("/:id/read", messageHandler.MarkAsRead)
+				messages.PUT("/:id", messageHandler.EditMessage)
+				messages.GET("/:id/edits", messageHandler.GetMessageEdits)
 				messages.DELETE("/:id", messageHandler.DeleteMessage)
+				messages.GET("/attachments/:id", messageHandler.DownloadAttachment)
+				messages.GET("/blocks", messageHandler.GetBlockedUsers)
+				messages.POST("/blocks", messageHandler.BlockUser)
+				messages.DELETE("/blocks/:user_id", messageHandler.UnblockUser)
 			}
+
+			// Content reports
+			protected.POST("/reports", moderationHandler.ReportContent)
 		}
 
 		// Payment confirmation (no authentication required for webhook)
 		api.GET("/orders/confirm-payment", orderHandler.ConfirmPayment)
 	}
 
+	// Uploaded files
+	router.GET("/uploads/*key", uploadHandler.ServeUpload)
+
 	// WebSocket route
 	router.GET("/ws", func(c *gin.Context) {
 		// Extract user info from query params or headers
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	ErrAttachmentTooLarge        = errors.New("attachment exceeds the maximum upload size")
+	ErrUnsupportedAttachmentType = errors.New("unsupported attachment type")
+)
+
+// attachmentExtensions maps the accepted attachment content types to file
+// extensions.
+var attachmentExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"application/pdf": ".pdf",
+}
+
+// AttachmentPolicy limits the files attached to messages.
+type AttachmentPolicy struct {
+	MaxBytes int64
+	MaxCount int
+}
+
+// AttachmentPolicyFromEnv reads the limits from MESSAGE_ATTACHMENT_MAX_MB
+// (default 10) and MESSAGE_MAX_ATTACHMENTS per message (default 5).
+func AttachmentPolicyFromEnv() AttachmentPolicy {
+	policy := AttachmentPolicy{MaxBytes: 10 << 20, MaxCount: 5}
+	if mb, err := strconv.Atoi(os.Getenv("MESSAGE_ATTACHMENT_MAX_MB")); err == nil && mb > 0 {
+		policy.MaxBytes = int64(mb) << 20
+	}
+	if count, err := strconv.Atoi(os.Getenv("MESSAGE_MAX_ATTACHMENTS")); err == nil && count >= 0 {
+		policy.MaxCount = count
+	}
+	return policy
+}
+
+// Check validates an attachment by its size and sniffed content type, and
+// returns the content type and the file extension to store it under.
+func (p AttachmentPolicy) Check(data []byte) (string, string, error) {
+	if int64(len(data)) > p.MaxBytes {
+		return "", "", ErrAttachmentTooLarge
+	}
+	contentType := http.DetectContentType(data)
+	extension, ok := attachmentExtensions[contentType]
+	if !ok {
+		return "", "", ErrUnsupportedAttachmentType
+	}
+	return contentType, extension, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// CartReminderConfig controls when abandoned carts are reminded about.
+type CartReminderConfig struct {
+	// AbandonedAfter is how long a cart has to be untouched, and how long
+	// to wait between reminders
+	AbandonedAfter time.Duration
+	// MaxReminders caps the reminders sent for one abandonment
+	MaxReminders int
+	// CartURL is the page reminders link back to
+	CartURL string
+}
+
+// CartReminderConfigFromEnv reads CART_ABANDONED_HOURS (default 24),
+// CART_MAX_REMINDERS (default 2) and APP_URL (default
+// http://localhost:3000).
+func CartReminderConfigFromEnv() CartReminderConfig {
+	config := CartReminderConfig{
+		AbandonedAfter: 24 * time.Hour,
+		MaxReminders:   2,
+		CartURL:        "http://localhost:3000/cart",
+	}
+	if hours, err := strconv.Atoi(os.Getenv("CART_ABANDONED_HOURS")); err == nil && hours > 0 {
+		config.AbandonedAfter = time.Duration(hours) * time.Hour
+	}
+	if max, err := strconv.Atoi(os.Getenv("CART_MAX_REMINDERS")); err == nil && max >= 0 {
+		config.MaxReminders = max
+	}
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		config.CartURL = strings.TrimRight(appURL, "/") + "/cart"
+	}
+	return config
+}
+
+// cartLastActivity returns when items of a cart were last added, changed or
+// removed.
+func cartLastActivity(db *gorm.DB, cart models.Cart) (time.Time, error) {
+	var items []models.CartItem
+	if err := db.Unscoped().Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	last := cart.CreatedAt
+	for _, item := range items {
+		if item.UpdatedAt.After(last) {
+			last = item.UpdatedAt
+		}
+		if item.DeletedAt.Valid && item.DeletedAt.Time.After(last) {
+			last = item.DeletedAt.Time
+		}
+	}
+	return last, nil
+}
+
+// SendCartReminders emails the owners of carts with items that have been
+// untouched for config.AbandonedAfter. Guest carts, users who opted out and
+// carts that already got config.MaxReminders since their last change are
+// skipped. It returns the number of reminders sent.
+func SendCartReminders(db *gorm.DB, email EmailServiceInterface, config CartReminderConfig) (int, error) {
+	if config.MaxReminders == 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	cutoff := now.Add(-config.AbandonedAfter)
+
+	var carts []models.Cart
+	if err := db.Preload("User").Preload("CartItems").
+		Joins("JOIN users ON users.id = carts.user_id AND users.deleted_at IS NULL").
+		Where("users.is_email_confirmed = ? AND users.cart_reminders_opt_out = ?", true, false).
+		Where("EXISTS (SELECT 1 FROM cart_items WHERE cart_items.cart_id = carts.id AND cart_items.deleted_at IS NULL)").
+		Find(&carts).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, cart := range carts {
+		lastActivity, err := cartLastActivity(db, cart)
+		if err != nil {
+			return sent, err
+		}
+		if lastActivity.After(cutoff) {
+			continue
+		}
+
+		// Only reminders since the last change count towards the cap
+		var reminders []models.CartReminder
+		if err := db.Where("cart_id = ? AND converted_at IS NULL AND sent_at > ?", cart.ID, lastActivity).
+			Order("sent_at DESC").Find(&reminders).Error; err != nil {
+			return sent, err
+		}
+		if len(reminders) >= config.MaxReminders || (len(reminders) > 0 && reminders[0].SentAt.After(cutoff)) {
+			continue
+		}
+
+		reminder := models.CartReminder{CartID: cart.ID, UserID: *cart.UserID, SentAt: now}
+		if err := db.Create(&reminder).Error; err != nil {
+			return sent, err
+		}
+
+		itemCount := 0
+		for _, item := range cart.CartItems {
+			itemCount += item.Quantity
+		}
+		cartURL := fmt.Sprintf("%s?reminder=%d", config.CartURL, reminder.ID)
+		if err := email.SendCartReminder(cart.User.Email, itemCount, cartURL); err != nil {
+			// Drop the record so the reminder is tried again next run
+			db.Delete(&reminder)
+			log.Printf("Failed to send cart reminder for cart %d: %v", cart.ID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// StartCartReminderScheduler sends abandoned cart reminders every interval.
+func StartCartReminderScheduler(db *gorm.DB, email EmailServiceInterface, interval time.Duration) {
+	config := CartReminderConfigFromEnv()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := SendCartReminders(db, email, config); err != nil {
+			log.Printf("Failed to send cart reminders: %v", err)
+		}
+	}
+}
+
+// RecordCartConversion marks the open reminders of a cart as converted by
+// the order placed from it.
+func RecordCartConversion(tx *gorm.DB, cartID, orderID uint) error {
+	return tx.Model(&models.CartReminder{}).
+		Where("cart_id = ? AND converted_at IS NULL", cartID).
+		Updates(map[string]interface{}{"converted_at": time.Now(), "order_id": orderID}).Error
+}
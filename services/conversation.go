@@ -0,0 +1,109 @@
+package services
+
+import (
+	"time"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// whereNullable matches column against value, or against NULL when value
+// is nil.
+func whereNullable(db *gorm.DB, column string, value *uint) *gorm.DB {
+	if value == nil {
+		return db.Where(column + " IS NULL")
+	}
+	return db.Where(column+" = ?", *value)
+}
+
+// FindConversation returns the conversation between two users about the
+// product or order, or about nothing in particular when both are nil.
+func FindConversation(db *gorm.DB, userID, otherID uint, productID, orderID *uint) (*models.Conversation, error) {
+	var conversation models.Conversation
+	query := db.Joins("JOIN conversation_participants a ON a.conversation_id = conversations.id AND a.user_id = ?", userID).
+		Joins("JOIN conversation_participants b ON b.conversation_id = conversations.id AND b.user_id = ?", otherID)
+	query = whereNullable(query, "conversations.product_id", productID)
+	query = whereNullable(query, "conversations.order_id", orderID)
+	if err := query.First(&conversation).Error; err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// FindOrCreateConversation returns the conversation between two users about
+// the product or order, creating it when they have none yet with userID as
+// the user who started it.
+func FindOrCreateConversation(tx *gorm.DB, userID, otherID uint, productID, orderID *uint) (*models.Conversation, error) {
+	conversation, err := FindConversation(tx, userID, otherID, productID, orderID)
+	if err == nil {
+		return conversation, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+
+	conversation = &models.Conversation{
+		ProductID:     productID,
+		OrderID:       orderID,
+		StartedByID:   userID,
+		LastMessageAt: time.Now(),
+		Participants: []models.ConversationParticipant{
+			{UserID: userID},
+			{UserID: otherID},
+		},
+	}
+	if err := tx.Create(conversation).Error; err != nil {
+		return nil, err
+	}
+	return conversation, nil
+}
+
+// HaveConversed reports whether two users share any conversation.
+func HaveConversed(db *gorm.DB, userID, otherID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.ConversationParticipant{}).
+		Joins("JOIN conversation_participants b ON b.conversation_id = conversation_participants.conversation_id AND b.user_id = ?", otherID).
+		Where("conversation_participants.user_id = ?", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CountUnansweredConversations counts the conversations the user started
+// since the given time in which nobody else has sent a message yet.
+func CountUnansweredConversations(db *gorm.DB, userID uint, since time.Time) (int64, error) {
+	var count int64
+	err := db.Model(&models.Conversation{}).
+		Where("started_by_id = ? AND created_at >= ?", userID, since).
+		Where("NOT EXISTS (?)", db.Session(&gorm.Session{NewDB: true}).Model(&models.Message{}).Select("1").
+			Where("messages.conversation_id = conversations.id AND messages.from_user_id <> ?", userID)).
+		Count(&count).Error
+	return count, err
+}
+
+// IsBlocked reports whether either user has blocked the other, and which
+// of them did.
+func IsBlocked(db *gorm.DB, userID, otherID uint) (blockedByUser, blockedByOther bool, err error) {
+	var blocks []models.UserBlock
+	if err := db.Where("(user_id = ? AND blocked_user_id = ?) OR (user_id = ? AND blocked_user_id = ?)",
+		userID, otherID, otherID, userID).Find(&blocks).Error; err != nil {
+		return false, false, err
+	}
+	for _, block := range blocks {
+		if block.UserID == userID {
+			blockedByUser = true
+		} else {
+			blockedByOther = true
+		}
+	}
+	return blockedByUser, blockedByOther, nil
+}
+
+// IsConversationMuted reports whether the user muted the conversation.
+func IsConversationMuted(db *gorm.DB, conversationID, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ? AND user_id = ? AND muted_at IS NOT NULL", conversationID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"html"
 	"os"
 	"strconv"
 
@@ -14,6 +15,10 @@ type EmailServiceInterface interface {
 	GenerateToken() (string, error)
 	SendEmailConfirmation(email, token string) error
 	SendPasswordReset(email, token string) error
+	SendBackInStock(email, productName string, productID uint) error
+	SendPriceDrop(email, productName string, productID uint, oldPrice, newPrice float64) error
+	SendCartReminder(email string, itemCount int, cartURL string) error
+	SendReviewResponse(email, productName string, productID uint, response string) error
 }
 
 type EmailService struct{}
@@ -54,6 +59,52 @@ func (s *EmailService) SendPasswordReset(email, token string) error {
 	return s.sendEmail(email, subject, body)
 }
 
+func (s *EmailService) SendBackInStock(email, productName string, productID uint) error {
+	subject := fmt.Sprintf("%s is back in stock", productName)
+	body := fmt.Sprintf(`
+		<h2>Back in Stock</h2>
+		<p>%s is available again. Order soon, stock may be limited.</p>
+		<a href="http://localhost:8080/api/products/%d">View Product</a>
+	`, html.EscapeString(productName), productID)
+
+	return s.sendEmail(email, subject, body)
+}
+
+func (s *EmailService) SendPriceDrop(email, productName string, productID uint, oldPrice, newPrice float64) error {
+	subject := fmt.Sprintf("Price drop on %s", productName)
+	body := fmt.Sprintf(`
+		<h2>Price Drop</h2>
+		<p>%s is now %.2f, down from %.2f.</p>
+		<a href="http://localhost:8080/api/products/%d">View Product</a>
+	`, html.EscapeString(productName), newPrice, oldPrice, productID)
+
+	return s.sendEmail(email, subject, body)
+}
+
+func (s *EmailService) SendCartReminder(email string, itemCount int, cartURL string) error {
+	subject := "You left items in your cart"
+	body := fmt.Sprintf(`
+		<h2>Still Thinking It Over?</h2>
+		<p>You have %d item(s) waiting in your cart.</p>
+		<a href="%s">Return to Your Cart</a>
+		<p>You can turn off cart reminders in your account preferences.</p>
+	`, itemCount, cartURL)
+
+	return s.sendEmail(email, subject, body)
+}
+
+func (s *EmailService) SendReviewResponse(email, productName string, productID uint, response string) error {
+	subject := fmt.Sprintf("The seller replied to your review of %s", productName)
+	body := fmt.Sprintf(`
+		<h2>The Seller Replied</h2>
+		<p>The seller of %s responded to your review:</p>
+		<blockquote>%s</blockquote>
+		<a href="http://localhost:8080/api/products/%d">View Product</a>
+	`, html.EscapeString(productName), html.EscapeString(response), productID)
+
+	return s.sendEmail(email, subject, body)
+}
+
 func (s *EmailService) sendEmail(to, subject, body string) error {
 	host := os.Getenv("SMTP_HOST")
 	portStr := os.Getenv("SMTP_PORT")
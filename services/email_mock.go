@@ -20,3 +20,23 @@ func (s *MockEmailService) SendPasswordReset(email, token string) error {
 	// Mock implementation - just return nil (success)
 	return nil
 }
+
+func (s *MockEmailService) SendBackInStock(email, productName string, productID uint) error {
+	// Mock implementation - just return nil (success)
+	return nil
+}
+
+func (s *MockEmailService) SendPriceDrop(email, productName string, productID uint, oldPrice, newPrice float64) error {
+	// Mock implementation - just return nil (success)
+	return nil
+}
+
+func (s *MockEmailService) SendCartReminder(email string, itemCount int, cartURL string) error {
+	// Mock implementation - just return nil (success)
+	return nil
+}
+
+func (s *MockEmailService) SendReviewResponse(email, productName string, productID uint, response string) error {
+	// Mock implementation - just return nil (success)
+	return nil
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // register the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var (
+	ErrImageTooLarge        = errors.New("image exceeds the maximum upload size")
+	ErrUnsupportedImageType = errors.New("unsupported image type")
+)
+
+// maxImagePixels bounds the decoded size of an upload.
+const maxImagePixels = 40_000_000
+
+// imageExtensions maps the accepted image content types to file extensions.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// ImageProcessor validates uploaded images and generates thumbnails.
+type ImageProcessor struct {
+	MaxBytes      int64
+	ThumbnailSize int
+}
+
+// NewImageProcessor reads the limits from IMAGE_MAX_UPLOAD_MB (default 5)
+// and IMAGE_THUMBNAIL_SIZE in pixels (default 320).
+func NewImageProcessor() *ImageProcessor {
+	processor := &ImageProcessor{MaxBytes: 5 << 20, ThumbnailSize: 320}
+	if mb, err := strconv.Atoi(os.Getenv("IMAGE_MAX_UPLOAD_MB")); err == nil && mb > 0 {
+		processor.MaxBytes = int64(mb) << 20
+	}
+	if size, err := strconv.Atoi(os.Getenv("IMAGE_THUMBNAIL_SIZE")); err == nil && size > 0 {
+		processor.ThumbnailSize = size
+	}
+	return processor
+}
+
+// ProcessedImage is a validated upload together with its thumbnail.
+type ProcessedImage struct {
+	Data                 []byte
+	ContentType          string
+	Extension            string
+	Width                int
+	Height               int
+	Thumbnail            []byte
+	ThumbnailContentType string
+	ThumbnailExtension   string
+}
+
+// Process checks the size and sniffed content type of data, decodes it to
+// make sure it is a well-formed image and renders a thumbnail that fits in
+// a ThumbnailSize square.
+func (p *ImageProcessor) Process(data []byte) (*ProcessedImage, error) {
+	if int64(len(data)) > p.MaxBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	extension, ok := imageExtensions[contentType]
+	if !ok {
+		return nil, ErrUnsupportedImageType
+	}
+
+	// Refuse huge dimensions before allocating memory for the pixels
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImageType
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImageType
+	}
+
+	thumb := Thumbnail(src, p.ThumbnailSize)
+
+	// JPEG has no transparency, so only JPEG sources get JPEG thumbnails
+	var buf bytes.Buffer
+	result := &ProcessedImage{
+		Data:        data,
+		ContentType: contentType,
+		Extension:   extension,
+		Width:       src.Bounds().Dx(),
+		Height:      src.Bounds().Dy(),
+	}
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		result.ThumbnailContentType, result.ThumbnailExtension = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(&buf, thumb)
+		result.ThumbnailContentType, result.ThumbnailExtension = "image/png", ".png"
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Thumbnail = buf.Bytes()
+
+	return result, nil
+}
+
+// Thumbnail scales src down with a box filter so that it fits in a
+// size x size square, keeping the aspect ratio. Images that already fit are
+// copied unscaled.
+func Thumbnail(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	rgba := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+	if dstW == srcW && dstH == srcH {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, (y+1)*srcH/dstH
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, (x+1)*srcW/dstW
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			// Average every source pixel covered by the destination pixel
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[offset])
+					g += int(rgba.Pix[offset+1])
+					b += int(rgba.Pix[offset+2])
+					a += int(rgba.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
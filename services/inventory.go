@@ -0,0 +1,182 @@
+package services
+
+import (
+	"errors"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// StockMovement describes a change to the stock of a product, or of one of
+// its variants when VariantID is set.
+type StockMovement struct {
+	ProductID uint
+	VariantID *uint
+	Type      models.InventoryMovementType
+	Quantity  int
+	Reason    string
+	ActorID   *uint
+	OrderID   *uint
+}
+
+// RecordStockMovement applies the movement to the stock column and appends
+// it to the ledger. The update is a single conditional statement, so
+// concurrent sales cannot take stock below zero; ErrInsufficientStock is
+// returned instead. Run it inside a transaction together with the change
+// that caused it.
+func RecordStockMovement(tx *gorm.DB, movement StockMovement) (*models.InventoryMovement, error) {
+	var model interface{} = &models.Product{}
+	id := movement.ProductID
+	if movement.VariantID != nil {
+		model = &models.ProductVariant{}
+		id = *movement.VariantID
+	}
+
+	// Deleted products still take back stock from cancellations and returns
+	result := tx.Unscoped().Model(model).Where("id = ? AND stock + ? >= 0", id, movement.Quantity).
+		UpdateColumn("stock", gorm.Expr("stock + ?", movement.Quantity))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInsufficientStock
+	}
+
+	var stockAfter int
+	if err := tx.Unscoped().Model(model).Where("id = ?", id).Select("stock").Row().Scan(&stockAfter); err != nil {
+		return nil, err
+	}
+
+	entry := models.InventoryMovement{
+		ProductID:  movement.ProductID,
+		VariantID:  movement.VariantID,
+		Type:       movement.Type,
+		Quantity:   movement.Quantity,
+		StockAfter: stockAfter,
+		Reason:     movement.Reason,
+		ActorID:    movement.ActorID,
+		OrderID:    movement.OrderID,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SetStock records the movement that brings the current stock to stock.
+// Nothing is recorded when the stock is unchanged.
+func SetStock(tx *gorm.DB, productID uint, variantID *uint, stock int, movementType models.InventoryMovementType, reason string, actorID *uint) error {
+	var model interface{} = &models.Product{}
+	id := productID
+	if variantID != nil {
+		model = &models.ProductVariant{}
+		id = *variantID
+	}
+
+	var current int
+	if err := tx.Model(model).Where("id = ?", id).Select("stock").Row().Scan(&current); err != nil {
+		return err
+	}
+	if current == stock {
+		return nil
+	}
+
+	_, err := RecordStockMovement(tx, StockMovement{
+		ProductID: productID,
+		VariantID: variantID,
+		Type:      movementType,
+		Quantity:  stock - current,
+		Reason:    reason,
+		ActorID:   actorID,
+	})
+	return err
+}
+
+// InventoryDrift is a product or variant whose stock column disagrees with
+// the sum of its ledger entries.
+type InventoryDrift struct {
+	ProductID   uint  `json:"product_id"`
+	VariantID   *uint `json:"variant_id"`
+	Stock       int   `json:"stock"`
+	LedgerStock int   `json:"ledger_stock"`
+}
+
+// Difference is the amount of stock not explained by the ledger.
+func (d InventoryDrift) Difference() int {
+	return d.Stock - d.LedgerStock
+}
+
+// ReconcileInventory compares every product and variant stock with its
+// ledger. With fix set, an adjustment is recorded for each drift so that the
+// ledger matches the stock again; the stock itself is never changed.
+func ReconcileInventory(db *gorm.DB, fix bool) ([]InventoryDrift, error) {
+	productDrifts, err := scanDrifts(db, `
+		SELECT products.id, NULL, products.stock, COALESCE(SUM(inventory_movements.quantity), 0)
+		FROM products
+		LEFT JOIN inventory_movements ON inventory_movements.product_id = products.id AND inventory_movements.variant_id IS NULL
+		WHERE products.deleted_at IS NULL
+		GROUP BY products.id, products.stock
+		ORDER BY products.id`)
+	if err != nil {
+		return nil, err
+	}
+
+	variantDrifts, err := scanDrifts(db, `
+		SELECT product_variants.product_id, product_variants.id, product_variants.stock, COALESCE(SUM(inventory_movements.quantity), 0)
+		FROM product_variants
+		LEFT JOIN inventory_movements ON inventory_movements.variant_id = product_variants.id
+		WHERE product_variants.deleted_at IS NULL
+		GROUP BY product_variants.product_id, product_variants.id, product_variants.stock
+		ORDER BY product_variants.id`)
+	if err != nil {
+		return nil, err
+	}
+	drifts := append(productDrifts, variantDrifts...)
+
+	if !fix || len(drifts) == 0 {
+		return drifts, nil
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, drift := range drifts {
+			entry := models.InventoryMovement{
+				ProductID:  drift.ProductID,
+				VariantID:  drift.VariantID,
+				Type:       models.InventoryMovementAdjustment,
+				Quantity:   drift.Difference(),
+				StockAfter: drift.Stock,
+				Reason:     "Reconciliation",
+			}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return drifts, err
+}
+
+// scanDrifts runs a query returning product ID, variant ID, stock and ledger
+// stock per row, and returns the rows where stock and ledger disagree.
+func scanDrifts(db *gorm.DB, query string) ([]InventoryDrift, error) {
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drifts []InventoryDrift
+	for rows.Next() {
+		var drift InventoryDrift
+		if err := rows.Scan(&drift.ProductID, &drift.VariantID, &drift.Stock, &drift.LedgerStock); err != nil {
+			return nil, err
+		}
+		if drift.Difference() != 0 {
+			drifts = append(drifts, drift)
+		}
+	}
+	return drifts, rows.Err()
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnknownContentType is returned for content types that cannot be
+// moderated.
+var ErrUnknownContentType = errors.New("unknown content type")
+
+// ContentFilter rejects text that contains banned words. Words are matched
+// whole and case-insensitively, so "class" does not match "ass".
+type ContentFilter struct {
+	words map[string]bool
+}
+
+// NewContentFilter returns a filter for the given banned words.
+func NewContentFilter(words []string) *ContentFilter {
+	filter := &ContentFilter{words: make(map[string]bool)}
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			filter.words[word] = true
+		}
+	}
+	return filter
+}
+
+// ContentFilterFromEnv returns a filter for the comma separated words in
+// MODERATION_BANNED_WORDS. Without the variable every text is allowed.
+func ContentFilterFromEnv() *ContentFilter {
+	return NewContentFilter(strings.Split(os.Getenv("MODERATION_BANNED_WORDS"), ","))
+}
+
+// Allows reports whether none of the texts contains a banned word.
+func (f *ContentFilter) Allows(texts ...string) bool {
+	if len(f.words) == 0 {
+		return true
+	}
+	for _, text := range texts {
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			if f.words[word] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AutoHideThreshold is the number of open reports that hides content until
+// a moderator decides, read from MODERATION_AUTO_HIDE_REPORTS (default 3,
+// 0 disables automatic hiding).
+func AutoHideThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("MODERATION_AUTO_HIDE_REPORTS")); err == nil && threshold >= 0 {
+		return threshold
+	}
+	return 3
+}
+
+// contentModel returns an empty model of the content type.
+func contentModel(contentType models.ContentType) (interface{}, error) {
+	switch contentType {
+	case models.ContentTypeReview:
+		return &models.Review{}, nil
+	case models.ContentTypeProduct:
+		return &models.Product{}, nil
+	case models.ContentTypeMessage:
+		return &models.Message{}, nil
+	}
+	return nil, ErrUnknownContentType
+}
+
+// SetContentHidden hides content from everyone but its author, or shows it
+// again. Hidden reviews do not count towards the product's rating.
+func SetContentHidden(tx *gorm.DB, contentType models.ContentType, contentID uint, hidden bool) error {
+	if contentType == models.ContentTypeReview {
+		var review models.Review
+		if err := tx.First(&review, contentID).Error; err != nil {
+			return err
+		}
+		if review.IsHidden == hidden {
+			return nil
+		}
+		delta := 1
+		if hidden {
+			delta = -1
+		}
+		if err := AdjustProductRating(tx, review.ProductID, review.Rating, delta); err != nil {
+			return err
+		}
+	}
+
+	model, err := contentModel(contentType)
+	if err != nil {
+		return err
+	}
+	return tx.Model(model).Where("id = ?", contentID).Update("is_hidden", hidden).Error
+}
+
+// DeleteContent deletes content on behalf of a moderator, cleaning up after
+// it like its author deleting it would. It returns the storage keys of files
+// to remove once tx commits.
+func DeleteContent(tx *gorm.DB, contentType models.ContentType, contentID uint) ([]string, error) {
+	switch contentType {
+	case models.ContentTypeReview:
+		var review models.Review
+		if err := tx.First(&review, contentID).Error; err != nil {
+			return nil, err
+		}
+		if !review.IsHidden {
+			if err := AdjustProductRating(tx, review.ProductID, review.Rating, -1); err != nil {
+				return nil, err
+			}
+		}
+	case models.ContentTypeProduct:
+		return DeleteProduct(tx, contentID)
+	}
+
+	model, err := contentModel(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return nil, tx.Delete(model, contentID).Error
+}
+
+// ResolveReports resolves the open reports of content and returns how many
+// there were.
+func ResolveReports(tx *gorm.DB, contentType models.ContentType, contentID uint) (int, error) {
+	result := tx.Model(&models.ContentReport{}).
+		Where("content_type = ? AND content_id = ? AND status = ?", contentType, contentID, models.ContentReportOpen).
+		Updates(map[string]interface{}{"status": models.ContentReportResolved, "resolved_at": time.Now()})
+	return int(result.RowsAffected), result.Error
+}
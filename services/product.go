@@ -0,0 +1,35 @@
+package services
+
+import (
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// DeleteProduct deletes a product, releasing its SKU so the seller can reuse
+// it for a new product, together with its images. It returns the storage
+// keys of the images, to be removed from storage once tx commits.
+func DeleteProduct(tx *gorm.DB, productID uint) ([]string, error) {
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Update("sku", nil).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Delete(&models.Product{}, productID).Error; err != nil {
+		return nil, err
+	}
+
+	var images []models.ProductImage
+	if err := tx.Where("product_id = ?", productID).Find(&images).Error; err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+	if err := tx.Unscoped().Where("product_id = ?", productID).Delete(&models.ProductImage{}).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, 2*len(images))
+	for _, image := range images {
+		keys = append(keys, image.StorageKey, image.ThumbnailKey)
+	}
+	return keys, nil
+}
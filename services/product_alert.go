@@ -0,0 +1,146 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// ProductAlertService tells subscribers when a product is back in stock or
+// its price dropped, by email and over the WebSocket hub.
+type ProductAlertService struct {
+	db        *gorm.DB
+	email     EmailServiceInterface
+	websocket *WebSocketService
+}
+
+// NewProductAlertService returns a ProductAlertService. websocket may be nil
+// to deliver alerts by email only.
+func NewProductAlertService(db *gorm.DB, email EmailServiceInterface, websocket *WebSocketService) *ProductAlertService {
+	return &ProductAlertService{db: db, email: email, websocket: websocket}
+}
+
+// ProductChanged compares a product before and after an update and alerts
+// the subscribers of a restock or a price drop. Failures are logged, they do
+// not undo the update.
+func (s *ProductAlertService) ProductChanged(before, after models.Product) {
+	if !after.IsAvailable() {
+		return
+	}
+	s.StockChanged(after.ID)
+	if after.Price < before.Price {
+		if _, err := s.NotifyPriceDrop(after); err != nil {
+			log.Printf("Failed to send price-drop alerts for product %d: %v", after.ID, err)
+		}
+	}
+}
+
+// StockChanged alerts the restock subscribers of a product once it can be
+// bought again, whether the product or one of its variants was restocked.
+// Restock alerts can only be subscribed while the product is out of stock,
+// so any alert still pending is due. Failures are logged.
+func (s *ProductAlertService) StockChanged(productID uint) {
+	var product models.Product
+	if err := s.db.First(&product, productID).Error; err != nil {
+		log.Printf("Failed to load product %d for restock alerts: %v", productID, err)
+		return
+	}
+	if !product.IsAvailable() {
+		return
+	}
+	inStock, err := ProductInStock(s.db, product.ID)
+	if err != nil {
+		log.Printf("Failed to check stock of product %d: %v", product.ID, err)
+		return
+	}
+	if !inStock {
+		return
+	}
+	if _, err := s.NotifyRestock(product); err != nil {
+		log.Printf("Failed to send restock alerts for product %d: %v", product.ID, err)
+	}
+}
+
+// NotifyRestock alerts the restock subscribers of product that have not been
+// alerted yet and returns how many were alerted.
+func (s *ProductAlertService) NotifyRestock(product models.Product) (int, error) {
+	var alerts []models.ProductAlert
+	if err := s.db.Preload("User").
+		Where("product_id = ? AND type = ? AND notified_at IS NULL", product.ID, models.ProductAlertRestock).
+		Find(&alerts).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, alert := range alerts {
+		// Claim the alert first so that concurrent updates send it only once
+		claim := s.db.Model(&models.ProductAlert{}).
+			Where("id = ? AND notified_at IS NULL", alert.ID).
+			Update("notified_at", time.Now())
+		if claim.Error != nil {
+			return sent, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.email.SendBackInStock(alert.User.Email, product.Name, product.ID); err != nil {
+			log.Printf("Failed to email restock alert %d: %v", alert.ID, err)
+		}
+		s.push(alert, map[string]interface{}{
+			"product_id": product.ID,
+			"name":       product.Name,
+			"stock":      product.Stock,
+		})
+		sent++
+	}
+	return sent, nil
+}
+
+// NotifyPriceDrop alerts the price-drop subscribers of product whose last
+// known price is above the current one and returns how many were alerted.
+func (s *ProductAlertService) NotifyPriceDrop(product models.Product) (int, error) {
+	var alerts []models.ProductAlert
+	if err := s.db.Preload("User").
+		Where("product_id = ? AND type = ? AND price > ?", product.ID, models.ProductAlertPriceDrop, product.Price).
+		Find(&alerts).Error; err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, alert := range alerts {
+		// Lowering the price is the claim, each new low is only sent once
+		claim := s.db.Model(&models.ProductAlert{}).
+			Where("id = ? AND price > ?", alert.ID, product.Price).
+			Updates(map[string]interface{}{"price": product.Price, "notified_at": time.Now()})
+		if claim.Error != nil {
+			return sent, claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			continue
+		}
+
+		if err := s.email.SendPriceDrop(alert.User.Email, product.Name, product.ID, alert.Price, product.Price); err != nil {
+			log.Printf("Failed to email price-drop alert %d: %v", alert.ID, err)
+		}
+		s.push(alert, map[string]interface{}{
+			"product_id":     product.ID,
+			"name":           product.Name,
+			"previous_price": alert.Price,
+			"price":          product.Price,
+		})
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *ProductAlertService) push(alert models.ProductAlert, data map[string]interface{}) {
+	if s.websocket == nil {
+		return
+	}
+	data["alert_id"] = alert.ID
+	s.websocket.SendNotification(alert.UserID, "product_"+string(alert.Type), data)
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"fmt"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+)
+
+// ratingSumSQL is the sum of all star ratings of a product, derived from
+// its histogram columns.
+const ratingSumSQL = "(rating1_count + 2 * rating2_count + 3 * rating3_count + 4 * rating4_count + 5 * rating5_count)"
+
+// AdjustProductRating adds delta reviews with the given star rating to the
+// aggregates of a product: delta is 1 for a new review and -1 for a deleted
+// one. The update is a single statement computed from the stored values, so
+// concurrent reviews do not overwrite each other's counts.
+func AdjustProductRating(tx *gorm.DB, productID uint, rating, delta int) error {
+	if rating < 1 || rating > 5 {
+		return fmt.Errorf("invalid rating %d", rating)
+	}
+	column := fmt.Sprintf("rating%d_count", rating)
+
+	return tx.Unscoped().Model(&models.Product{}).Where("id = ?", productID).UpdateColumns(map[string]interface{}{
+		column:         gorm.Expr(column+" + ?", delta),
+		"review_count": gorm.Expr("review_count + ?", delta),
+		"average_rating": gorm.Expr(
+			"COALESCE(("+ratingSumSQL+" + ?) * 1.0 / NULLIF(review_count + ?, 0), 0)",
+			rating*delta, delta,
+		),
+	}).Error
+}
+
+// RecalculateProductRatings rebuilds the rating aggregates of every product
+// from its reviews that are not hidden by moderation.
+func RecalculateProductRatings(db *gorm.DB) error {
+	var rows []struct {
+		ProductID uint
+		Rating    int
+		Count     int
+	}
+	if err := db.Model(&models.Review{}).
+		Select("product_id, rating, COUNT(*) AS count").
+		Where("is_hidden = ?", false).
+		Group("product_id, rating").
+		Scan(&rows).Error; err != nil {
+		return err
+	}
+
+	histograms := make(map[uint][6]int)
+	for _, row := range rows {
+		if row.Rating < 1 || row.Rating > 5 {
+			continue
+		}
+		histogram := histograms[row.ProductID]
+		histogram[row.Rating] = row.Count
+		histograms[row.ProductID] = histogram
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		reset := map[string]interface{}{"average_rating": 0, "review_count": 0}
+		for rating := 1; rating <= 5; rating++ {
+			reset[fmt.Sprintf("rating%d_count", rating)] = 0
+		}
+		if err := tx.Unscoped().Model(&models.Product{}).Where("review_count <> 0").UpdateColumns(reset).Error; err != nil {
+			return err
+		}
+
+		for productID, histogram := range histograms {
+			columns := map[string]interface{}{}
+			count, sum := 0, 0
+			for rating := 1; rating <= 5; rating++ {
+				columns[fmt.Sprintf("rating%d_count", rating)] = histogram[rating]
+				count += histogram[rating]
+				sum += rating * histogram[rating]
+			}
+			columns["review_count"] = count
+			columns["average_rating"] = float64(sum) / float64(count)
+			if err := tx.Unscoped().Model(&models.Product{}).Where("id = ?", productID).UpdateColumns(columns).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"ecommerce-app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReservationTTL is how long checkout holds stock, read from
+// CART_RESERVATION_MINUTES (default 15).
+func ReservationTTL() time.Duration {
+	if minutes, err := strconv.Atoi(os.Getenv("CART_RESERVATION_MINUTES")); err == nil && minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return 15 * time.Minute
+}
+
+// ReservedStock returns the quantity of a product, or of one of its variants,
+// held by unexpired reservations of carts other than exceptCartID.
+func ReservedStock(db *gorm.DB, productID uint, variantID *uint, exceptCartID uint) (int, error) {
+	query := db.Model(&models.StockReservation{}).
+		Where("product_id = ? AND cart_id <> ? AND expires_at > ?", productID, exceptCartID, time.Now())
+	if variantID != nil {
+		query = query.Where("variant_id = ?", *variantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+
+	var reserved int
+	if err := query.Select("COALESCE(SUM(quantity), 0)").Row().Scan(&reserved); err != nil {
+		return 0, err
+	}
+	return reserved, nil
+}
+
+// AvailableStock returns the stock of a product or variant that is not held
+// for other carts. Pass the shopper's own cart ID so their reservations count
+// as available to them.
+func AvailableStock(db *gorm.DB, productID uint, variantID *uint, cartID uint) (int, error) {
+	var model interface{} = &models.Product{}
+	id := productID
+	if variantID != nil {
+		model = &models.ProductVariant{}
+		id = *variantID
+	}
+
+	var stock int
+	if err := db.Model(model).Where("id = ?", id).Select("stock").Row().Scan(&stock); err != nil {
+		return 0, err
+	}
+
+	reserved, err := ReservedStock(db, productID, variantID, cartID)
+	if err != nil {
+		return 0, err
+	}
+	return stock - reserved, nil
+}
+
+// LockRow locks the row of model with the given ID until tx ends, so that
+// concurrent transactions checking and changing what belongs to it run one
+// after the other. Only Postgres takes row locks; SQLite already serializes
+// writing transactions.
+func LockRow(tx *gorm.DB, model interface{}, id uint) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	var locked []uint
+	return tx.Model(model).Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).Pluck("id", &locked).Error
+}
+
+// LockStock locks the stock row of a product, or of one of its variants,
+// until tx ends, so that concurrent transactions checking and reserving it
+// run one after the other.
+func LockStock(tx *gorm.DB, productID uint, variantID *uint) error {
+	if variantID != nil {
+		return LockRow(tx, &models.ProductVariant{}, *variantID)
+	}
+	return LockRow(tx, &models.Product{}, productID)
+}
+
+// ProductInStock reports whether any of a product can be bought: one of its
+// active variants when it has any, as the cart requires, or else the product
+// itself.
+func ProductInStock(db *gorm.DB, productID uint) (bool, error) {
+	var variantIDs []uint
+	if err := db.Model(&models.ProductVariant{}).
+		Where("product_id = ? AND is_active = ?", productID, true).
+		Pluck("id", &variantIDs).Error; err != nil {
+		return false, err
+	}
+	if len(variantIDs) == 0 {
+		stock, err := AvailableStock(db, productID, nil, 0)
+		return stock > 0, err
+	}
+	for _, variantID := range variantIDs {
+		stock, err := AvailableStock(db, productID, &variantID, 0)
+		if err != nil {
+			return false, err
+		}
+		if stock > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReleaseExpiredReservations deletes reservations past their expiry. Expired
+// reservations are already ignored by ReservedStock; this only keeps the
+// table small.
+func ReleaseExpiredReservations(db *gorm.DB) (int64, error) {
+	result := db.Where("expires_at <= ?", time.Now()).Delete(&models.StockReservation{})
+	return result.RowsAffected, result.Error
+}
+
+// StartReservationSweeper releases expired reservations every interval.
+func StartReservationSweeper(db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := ReleaseExpiredReservations(db); err != nil {
+			log.Printf("Failed to release expired stock reservations: %v", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProductSearch restricts product queries to a free-text search term and
+// orders them by relevance.
+type ProductSearch interface {
+	// Setup prepares the database objects the implementation relies on.
+	Setup() error
+	// Apply filters query to products matching term.
+	Apply(query *gorm.DB, term string) *gorm.DB
+	// Rank orders query by relevance to term, best match first and the
+	// newest product first among equal matches.
+	Rank(query *gorm.DB, term string) *gorm.DB
+}
+
+// NewProductSearch returns the Postgres full-text implementation when db is
+// backed by Postgres and a portable LIKE based implementation otherwise.
+func NewProductSearch(db *gorm.DB) ProductSearch {
+	if db.Dialector.Name() == "postgres" {
+		return &PostgresProductSearch{db: db}
+	}
+	return &LikeProductSearch{}
+}
+
+// searchTerms splits a user supplied search string into plain words,
+// dropping anything that could be interpreted as query syntax.
+func searchTerms(term string) []string {
+	return strings.FieldsFunc(strings.ToLower(term), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// PostgresProductSearch searches a weighted tsvector column kept up to date
+// by a trigger and backed by a GIN index.
+type PostgresProductSearch struct {
+	db *gorm.DB
+}
+
+const postgresSearchSetup = `
+ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION products_search_vector_update() RETURNS trigger AS $$
+BEGIN
+	NEW.search_vector :=
+		setweight(to_tsvector('english', coalesce(NEW.name, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(NEW.description, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce((SELECT name FROM categories WHERE id = NEW.category_id), '')), 'C');
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS products_search_vector_trigger ON products;
+CREATE TRIGGER products_search_vector_trigger
+	BEFORE INSERT OR UPDATE ON products
+	FOR EACH ROW EXECUTE FUNCTION products_search_vector_update();
+
+CREATE OR REPLACE FUNCTION categories_search_vector_refresh() RETURNS trigger AS $$
+BEGIN
+	UPDATE products SET name = name WHERE category_id = NEW.id;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS categories_search_vector_trigger ON categories;
+CREATE TRIGGER categories_search_vector_trigger
+	AFTER UPDATE OF name ON categories
+	FOR EACH ROW EXECUTE FUNCTION categories_search_vector_refresh();
+
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+
+UPDATE products SET name = name WHERE search_vector IS NULL;
+`
+
+func (s *PostgresProductSearch) Setup() error {
+	return s.db.Exec(postgresSearchSetup).Error
+}
+
+// tsquery requires every word to match, as a prefix so partially typed
+// words still hit.
+func (s *PostgresProductSearch) tsquery(words []string) string {
+	prefixes := make([]string, len(words))
+	for i, word := range words {
+		prefixes[i] = word + ":*"
+	}
+	return strings.Join(prefixes, " & ")
+}
+
+func (s *PostgresProductSearch) Apply(query *gorm.DB, term string) *gorm.DB {
+	words := searchTerms(term)
+	if len(words) == 0 {
+		return query
+	}
+	return query.Where("products.search_vector @@ to_tsquery('english', ?)", s.tsquery(words))
+}
+
+func (s *PostgresProductSearch) Rank(query *gorm.DB, term string) *gorm.DB {
+	words := searchTerms(term)
+	if len(words) == 0 {
+		return query.Order("products.id DESC")
+	}
+	return query.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:  "ts_rank(products.search_vector, to_tsquery('english', ?)) DESC, products.id DESC",
+		Vars: []interface{}{s.tsquery(words)},
+	}})
+}
+
+// LikeProductSearch is the fallback used on databases without full-text
+// search support, such as the SQLite database used by the tests. It requires
+// every word to appear in the name, description or category name and ranks
+// name matches above description and category matches.
+type LikeProductSearch struct{}
+
+func (s *LikeProductSearch) Setup() error {
+	return nil
+}
+
+const likeCategoryName = "(SELECT name FROM categories WHERE categories.id = products.category_id)"
+
+func (s *LikeProductSearch) Apply(query *gorm.DB, term string) *gorm.DB {
+	words := searchTerms(term)
+	if len(words) == 0 {
+		return query
+	}
+
+	for _, word := range words {
+		pattern := "%" + word + "%"
+		query = query.Where("(LOWER(products.name) LIKE ? OR LOWER(products.description) LIKE ? OR LOWER("+likeCategoryName+") LIKE ?)",
+			pattern, pattern, pattern)
+	}
+	return query
+}
+
+func (s *LikeProductSearch) Rank(query *gorm.DB, term string) *gorm.DB {
+	words := searchTerms(term)
+	if len(words) == 0 {
+		return query.Order("products.id DESC")
+	}
+
+	var rankSQL []string
+	var rankVars []interface{}
+	for _, word := range words {
+		pattern := "%" + word + "%"
+		rankSQL = append(rankSQL,
+			"CASE WHEN LOWER(products.name) LIKE ? THEN 4 ELSE 0 END",
+			"CASE WHEN LOWER(products.description) LIKE ? THEN 2 ELSE 0 END",
+			"CASE WHEN LOWER("+likeCategoryName+") LIKE ? THEN 1 ELSE 0 END",
+		)
+		rankVars = append(rankVars, pattern, pattern, pattern)
+	}
+
+	return query.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:  "(" + strings.Join(rankSQL, " + ") + ") DESC, products.id DESC",
+		Vars: rankVars,
+	}})
+}
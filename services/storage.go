@@ -0,0 +1,261 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage stores uploaded files under slash separated keys.
+type Storage interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) (io.ReadCloser, string, error)
+	Delete(key string) error
+	URL(key string) string
+}
+
+// NewStorage returns the storage backend selected by STORAGE_DRIVER, which is
+// either "local" (the default) or "s3".
+func NewStorage() Storage {
+	if os.Getenv("STORAGE_DRIVER") == "s3" {
+		return NewS3Storage(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		})
+	}
+
+	dir := os.Getenv("UPLOAD_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return NewLocalStorage(dir, "/uploads")
+}
+
+// NewStorageKey returns a random key below prefix with the given extension.
+func NewStorageKey(prefix, ext string) (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return path.Join(prefix, hex.EncodeToString(bytes)+ext), nil
+}
+
+// LocalStorage keeps files in a directory on the local filesystem.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) *LocalStorage {
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// path maps key to a file below Dir, rejecting keys that would escape it.
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "..") {
+		return "", fmt.Errorf("invalid storage key: %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStorage) Put(key string, data []byte, contentType string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0o644)
+}
+
+func (s *LocalStorage) Get(key string) (io.ReadCloser, string, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrObjectNotFound
+		}
+		return nil, "", err
+	}
+	return file, mime.TypeByExtension(path.Ext(key)), nil
+}
+
+func (s *LocalStorage) Delete(key string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
+
+type S3Config struct {
+	Endpoint        string // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PublicURL       string // base URL objects are served from, defaults to Endpoint/Bucket
+}
+
+// S3Storage talks to any S3 compatible service using path-style requests
+// signed with AWS Signature Version 4.
+type S3Storage struct {
+	config S3Config
+	client *http.Client
+}
+
+func NewS3Storage(config S3Config) *S3Storage {
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.PublicURL == "" {
+		config.PublicURL = config.Endpoint + "/" + config.Bucket
+	}
+	return &S3Storage{
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return s.config.Endpoint + "/" + url.PathEscape(s.config.Bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (s *S3Storage) do(method, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (s *S3Storage) Put(key string, data []byte, contentType string) error {
+	resp, err := s.do(http.MethodPut, key, data, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("s3 put %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, string, error) {
+	resp, err := s.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, "", ErrObjectNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("s3 get %s: unexpected status %s", key, resp.Status)
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func (s *S3Storage) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 delete %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *S3Storage) URL(key string) string {
+	return strings.TrimSuffix(s.config.PublicURL, "/") + "/" + key
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
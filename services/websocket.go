@@ -68,8 +68,8 @@ func (s *WebSocketService) StartHub() {
 		case message := <-s.broadcast:
 			s.mutex.RLock()
 			for _, client := range s.clients {
-				// Send private message only to the intended recipient
-				if message.Type == "private_message" && client.UserID == message.ToUserID {
+				// Send private messages and notifications only to the intended recipient
+				if (message.Type == "private_message" || message.Type == "notification") && client.UserID == message.ToUserID {
 					select {
 					case client.Send <- s.serializeMessage(message):
 					default:
@@ -175,3 +175,15 @@ func (s *WebSocketService) SendPrivateMessage(fromUserID, toUserID uint, content
 	}
 	s.broadcast <- message
 }
+
+// SendNotification delivers a notification, such as a product alert, to the
+// connections of a user.
+func (s *WebSocketService) SendNotification(toUserID uint, kind string, data interface{}) {
+	message := Message{
+		Type:     "notification",
+		ToUserID: toUserID,
+		Content:  kind,
+		Data:     data,
+	}
+	s.broadcast <- message
+}
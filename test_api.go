@@ -26,13 +26,35 @@ func setupTestDB() *gorm.DB {
 	// Auto migrate all models
 	err = db.AutoMigrate(
 		&models.User{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductOption{},
+		&models.ProductOptionValue{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
 		&models.Order{},
 		&models.OrderItem{},
 		&models.Cart{},
 		&models.CartItem{},
+		&models.StockReservation{},
+		&models.CartReminder{},
+		&models.Wishlist{},
+		&models.WishlistItem{},
+		&models.ProductAlert{},
 		&models.Review{},
+		&models.ReviewVote{},
+		&models.ReviewResponse{},
+		&models.ReviewPhoto{},
+		&models.Conversation{},
+		&models.ConversationParticipant{},
 		&models.Message{},
+		&models.MessageAttachment{},
+		&models.MessageEdit{},
+		&models.UserBlock{},
+		&models.ContentReport{},
+		&models.ModerationAction{},
+		&models.ImportJob{},
+		&models.InventoryMovement{},
 	)
 	if err != nil {
 		panic("failed to migrate database")
@@ -117,7 +139,7 @@ func TestProductCreation(t *testing.T) {
 	// Setup
 	gin.SetMode(gin.TestMode)
 	db := setupTestDB()
-	productHandler := handlers.NewProductHandler(db)
+	productHandler := handlers.NewProductHandler(db, services.NewProductSearch(db), services.NewLocalStorage(t.TempDir(), "/uploads"), services.NewImageProcessor(), services.NewProductAlertService(db, services.NewMockEmailService(), nil))
 
 	router := gin.New()
 	router.POST("/products", productHandler.CreateProduct)